@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
@@ -16,20 +17,27 @@ import (
 func GetDistribution(c *gin.Context, pool *pgxpool.Pool) {
 	filters, valid := parseQueryFilters(c)
 	if !valid {
-		slog.Warn("invalid distribution request params", "vehicle", c.Query("vehicle_id"))
+		slog.Warn("invalid distribution request params", "vehicle", c.Query("vehicle_id"), "fleet", c.Query("fleet"))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
 		return
 	}
+	perVehicle, err := parseGroupBy(c)
+	if err != nil {
+		slog.Warn("invalid distribution params", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	metric := c.DefaultQuery("metric", "speed")
-	if err := validateMetric(metric); err != nil {
+	m, err := lookupMetric(metric)
+	if err != nil {
 		slog.Warn("invalid distribution params", "metric", metric, "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "metric is not valid"})
 		return
 	}
 
 	slog.Info("handling distribution request",
-		"metric", metric, "vehicle", filters.VehicleID, "start", filters.Start, "end", filters.End)
+		"metric", metric, "vehicles", filters.label(), "start", filters.Start, "end", filters.End)
 
 	binsStr := c.DefaultQuery("bins", "10")
 	bins, err := strconv.Atoi(binsStr)
@@ -38,22 +46,22 @@ func GetDistribution(c *gin.Context, pool *pgxpool.Pool) {
 		bins = 10
 	}
 
-	col := allowedMetrics[metric]
+	col := m.Column
 
 	// Compute min/max with the same pattern as KPIs/Trend
 	minMaxQuery := fmt.Sprintf(`
 		SELECT MIN(%s), MAX(%s)
 		FROM telemetry
-		WHERE vehicle_id = $1
+		WHERE %s
 		  AND time_iso >= $2::timestamptz
 		  AND time_iso <= $3::timestamptz
-	`, col, col)
+	`, col, col, vehicleCondition)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var min, max *float64
-	err = pool.QueryRow(ctx, minMaxQuery, filters.VehicleID, filters.Start, filters.End).Scan(&min, &max)
+	err = pool.QueryRow(ctx, minMaxQuery, filters.vehicleArg(), filters.Start, filters.End).Scan(&min, &max)
 
 	if err != nil {
 		slog.Error("min max query failed", "error", err)
@@ -63,10 +71,10 @@ func GetDistribution(c *gin.Context, pool *pgxpool.Pool) {
 
 	if min == nil || max == nil || *min == *max {
 		slog.Info("distribution query returned no range",
-			"metric", metric, "vehicle", filters.VehicleID)
+			"metric", metric, "vehicles", filters.label())
 		c.JSON(http.StatusOK, DistributionResponse{
 			Metric:  metric,
-			Vehicle: filters.VehicleID,
+			Vehicle: filters.label(),
 			Bins:    bins,
 			Min:     nil,
 			Max:     nil,
@@ -77,29 +85,33 @@ func GetDistribution(c *gin.Context, pool *pgxpool.Pool) {
 		return
 	}
 
-	// Bucket query
+	// Bucket query, with the same bins for every vehicle
+	groupCol := "NULL::text"
+	if perVehicle {
+		groupCol = "vehicle_id"
+	}
 	bucketQuery := fmt.Sprintf(`
 		WITH bounds AS (
 			SELECT MIN(%[1]s) AS minval, MAX(%[1]s) AS maxval
 			FROM telemetry
-			WHERE vehicle_id = $1
+			WHERE %[2]s
 			  AND time_iso >= $2::timestamptz
 			  AND time_iso <= $3::timestamptz
 		)
-		SELECT bucket, COUNT(*) AS cnt, bounds.minval, bounds.maxval
+		SELECT vehicle, bucket, COUNT(*) AS cnt, bounds.minval, bounds.maxval
 		FROM (
-			SELECT width_bucket(%[1]s, bounds.minval, bounds.maxval, $4) AS bucket
+			SELECT %[3]s AS vehicle, width_bucket(%[1]s, bounds.minval, bounds.maxval, $4) AS bucket
 			FROM telemetry, bounds
-			WHERE vehicle_id = $1
+			WHERE %[2]s
 			  AND time_iso >= $2::timestamptz
 			  AND time_iso <= $3::timestamptz
 			  AND %[1]s IS NOT NULL
 		) sub, bounds
-		GROUP BY bucket, bounds.minval, bounds.maxval
-		ORDER BY bucket
-	`, col)
+		GROUP BY vehicle, bucket, bounds.minval, bounds.maxval
+		ORDER BY vehicle, bucket
+	`, col, vehicleCondition, groupCol)
 
-	rows, err := pool.Query(ctx, bucketQuery, filters.VehicleID, filters.Start, filters.End, bins)
+	rows, err := pool.Query(ctx, bucketQuery, filters.vehicleArg(), filters.Start, filters.End, bins)
 	if err != nil {
 		slog.Error("bucket query failed", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "bucket query failed"})
@@ -108,12 +120,15 @@ func GetDistribution(c *gin.Context, pool *pgxpool.Pool) {
 	defer rows.Close()
 
 	var out []Bucket
+	var series []DistributionSeries
+	pooled := make(map[int]int) // bucket -> index in out
 	bucketWidth := (*max - *min) / float64(bins)
 
 	for rows.Next() {
 		var b Bucket
+		var vehicle *string
 		var minVal, maxVal float64
-		if err := rows.Scan(&b.Bucket, &b.Count, &minVal, &maxVal); err != nil {
+		if err := rows.Scan(&vehicle, &b.Bucket, &b.Count, &minVal, &maxVal); err != nil {
 			slog.Error("row scan failed inside distribution", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
 			return
@@ -121,32 +136,53 @@ func GetDistribution(c *gin.Context, pool *pgxpool.Pool) {
 
 		b.RangeMin = minVal + float64(b.Bucket-1)*bucketWidth
 		b.RangeMax = minVal + float64(b.Bucket)*bucketWidth
+
+		if vehicle != nil {
+			if len(series) == 0 || series[len(series)-1].VehicleID != *vehicle {
+				series = append(series, DistributionSeries{VehicleID: *vehicle})
+			}
+			series[len(series)-1].Buckets = append(series[len(series)-1].Buckets, b)
+		}
+		if i, ok := pooled[b.Bucket]; ok {
+			out[i].Count += b.Count
+			continue
+		}
+		pooled[b.Bucket] = len(out)
 		out = append(out, b)
 	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Bucket < out[j].Bucket })
 
 	slog.Info("distribution computed successfully",
-		"metric", metric, "vehicle", filters.VehicleID, "bins", bins, "bucket_count", len(out))
+		"metric", metric, "vehicles", filters.label(), "bins", bins, "bucket_count", len(out))
 	c.JSON(http.StatusOK, DistributionResponse{
 		Metric:  metric,
-		Vehicle: filters.VehicleID,
+		Vehicle: filters.label(),
 		Bins:    bins,
 		Min:     min,
 		Max:     max,
 		From:    filters.Start,
 		To:      filters.End,
 		Buckets: out,
+		Series:  series,
 	})
 }
 
 type DistributionResponse struct {
-	Metric  string    `json:"metric"`
-	Vehicle string    `json:"vehicle"`
-	Bins    int       `json:"bins"`
-	Min     *float64  `json:"min"`
-	Max     *float64  `json:"max"`
-	From    time.Time `json:"from,omitempty"`
-	To      time.Time `json:"to,omitempty"`
-	Buckets []Bucket  `json:"buckets"`
+	Metric  string               `json:"metric"`
+	Vehicle string               `json:"vehicle"`
+	Bins    int                  `json:"bins"`
+	Min     *float64             `json:"min"`
+	Max     *float64             `json:"max"`
+	From    time.Time            `json:"from,omitempty"`
+	To      time.Time            `json:"to,omitempty"`
+	Buckets []Bucket             `json:"buckets"`          // pooled over the selected vehicles
+	Series  []DistributionSeries `json:"series,omitempty"` // per vehicle, with group_by=vehicle
+}
+
+// DistributionSeries is the distribution of one vehicle.
+type DistributionSeries struct {
+	VehicleID string   `json:"vehicle_id"`
+	Buckets   []Bucket `json:"buckets"`
 }
 
 type Bucket struct {
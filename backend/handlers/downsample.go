@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"math"
+	"time"
+)
+
+// trendSample is a trend point before it is downsampled and formatted.
+type trendSample struct {
+	t time.Time
+	v float64
+}
+
+// lttb downsamples samples to at most threshold points with
+// Largest-Triangle-Three-Buckets: the first and last samples are kept, and
+// of every bucket in between the sample forming the largest triangle with
+// the previously selected sample and the average of the next bucket. Peaks
+// and the overall shape of the series survive, unlike with plain averaging.
+func lttb(samples []trendSample, threshold int) []trendSample {
+	if threshold < 3 || len(samples) <= threshold {
+		return samples
+	}
+
+	x := func(i int) float64 { return float64(samples[i].t.UnixMilli()) }
+	out := make([]trendSample, 0, threshold)
+	out = append(out, samples[0])
+
+	// Buckets between the first and last sample
+	every := float64(len(samples)-2) / float64(threshold-2)
+	a := 0
+	for i := 0; i < threshold-2; i++ {
+		// Average of the next bucket (the last sample for the last bucket)
+		nextStart := int(math.Floor(float64(i+1)*every)) + 1
+		nextEnd := min(int(math.Floor(float64(i+2)*every))+1, len(samples))
+		if nextStart >= nextEnd {
+			nextStart, nextEnd = len(samples)-1, len(samples)
+		}
+		var avgX, avgY float64
+		for j := nextStart; j < nextEnd; j++ {
+			avgX += x(j)
+			avgY += samples[j].v
+		}
+		n := float64(nextEnd - nextStart)
+		avgX, avgY = avgX/n, avgY/n
+
+		// Sample of the current bucket with the largest triangle
+		start := int(math.Floor(float64(i)*every)) + 1
+		end := int(math.Floor(float64(i+1)*every)) + 1
+		ax, ay := x(a), samples[a].v
+		best, bestArea := start, -1.0
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(samples[j].v-ay) - (ax-x(j))*(avgY-ay))
+			if area > bestArea {
+				best, bestArea = j, area
+			}
+		}
+		out = append(out, samples[best])
+		a = best
+	}
+
+	return append(out, samples[len(samples)-1])
+}
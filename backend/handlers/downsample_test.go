@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// series returns n samples one second apart with values f(i).
+func series(n int, f func(i int) float64) []trendSample {
+	t0 := time.Date(2019, 6, 24, 3, 16, 0, 0, time.UTC)
+	out := make([]trendSample, n)
+	for i := range out {
+		out[i] = trendSample{t: t0.Add(time.Duration(i) * time.Second), v: f(i)}
+	}
+	return out
+}
+
+func flat(int) float64 { return 1 }
+
+func TestLTTBPassThrough(t *testing.T) {
+	// Series that already fit, and thresholds too small to downsample with
+	for threshold, samples := range map[int][]trendSample{
+		10: series(10, flat),
+		11: series(5, flat),
+		2:  series(100, flat),
+		0:  series(100, flat),
+	} {
+		if got := lttb(samples, threshold); len(got) != len(samples) {
+			t.Errorf("threshold %d: %d of %d samples", threshold, len(got), len(samples))
+		}
+	}
+	if got := lttb(nil, 10); len(got) != 0 {
+		t.Errorf("empty series: %d samples", len(got))
+	}
+}
+
+func TestLTTBKeepsShape(t *testing.T) {
+	keeps := func(samples []trendSample, threshold int, indices ...int) {
+		t.Helper()
+		got := lttb(samples, threshold)
+		if len(got) != threshold {
+			t.Fatalf("%d samples, want %d", len(got), threshold)
+		}
+		kept := make(map[time.Time]float64, len(got))
+		for i, s := range got {
+			if i > 0 && !s.t.After(got[i-1].t) {
+				t.Fatalf("samples out of order at %d", i)
+			}
+			kept[s.t] = s.v
+		}
+		for _, i := range indices {
+			if v, ok := kept[samples[i].t]; !ok || v != samples[i].v {
+				t.Errorf("sample %d (%v) was dropped", i, samples[i].v)
+			}
+		}
+	}
+
+	spike := series(1000, flat)
+	spike[137].v = 100
+	keeps(spike, 50, 0, 137, 999)
+	keeps(spike[:100], 3, 0, 99)
+
+	wave := series(5000, func(i int) float64 {
+		if i == 600 {
+			return -50
+		}
+		return math.Sin(float64(i) / 50)
+	})
+	keeps(wave, 200, 0, 600, 4999)
+}
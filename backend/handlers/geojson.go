@@ -0,0 +1,46 @@
+package handlers
+
+import "math"
+
+// GeoJSON (RFC 7946) types used by the map endpoints.
+
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+	// Foreign members describing the collection as a whole
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+func newFeatureCollection() GeoJSONFeatureCollection {
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+}
+
+func newFeature(geometryType string, coordinates interface{}, properties map[string]interface{}) GeoJSONFeature {
+	return GeoJSONFeature{
+		Type:       "Feature",
+		Geometry:   GeoJSONGeometry{Type: geometryType, Coordinates: coordinates},
+		Properties: properties,
+	}
+}
+
+const earthRadius = 6371008.8 // mean radius in meters
+
+// localMeters projects a position to meters on a plane tangent at lat0, lon0
+// (equirectangular), accurate enough for distances along a city bus route.
+func localMeters(lat, lon, lat0, lon0 float64) (x, y float64) {
+	rad := math.Pi / 180
+	x = (lon - lon0) * rad * earthRadius * math.Cos(lat0*rad)
+	y = (lat - lat0) * rad * earthRadius
+	return x, y
+}
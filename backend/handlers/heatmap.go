@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Geohash precisions accepted by /heatmap: 1 (~5000 km) to 9 (~5 m).
+const (
+	defaultGeohashPrecision = 7
+	maxGeohashPrecision     = 9
+)
+
+// HEATMAP_MAX_CELLS caps the grid cells a /heatmap response may hold.
+var heatmapMaxCells = envInt64("HEATMAP_MAX_CELLS", 50000)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohashBits returns the number of longitude and latitude bits of a
+// geohash. Bits alternate starting with longitude, 5 per character.
+func geohashBits(precision int) (lonBits, latBits int) {
+	bits := 5 * precision
+	return (bits + 1) / 2, bits / 2
+}
+
+// geohashCell encodes the cell at column lonIdx and row latIdx of the
+// grid of the given precision.
+func geohashCell(lonIdx, latIdx int64, precision int) string {
+	lonBits, latBits := geohashBits(precision)
+	out := make([]byte, precision)
+	var ch, n int
+	for i := 0; i < 5*precision; i++ {
+		var bit int64
+		if i%2 == 0 {
+			lonBits--
+			bit = lonIdx >> lonBits & 1
+		} else {
+			latBits--
+			bit = latIdx >> latBits & 1
+		}
+		ch = ch<<1 | int(bit)
+		if i%5 == 4 {
+			out[n] = geohashAlphabet[ch]
+			ch, n = 0, n+1
+		}
+	}
+	return string(out)
+}
+
+// geohashBounds returns the south-west and north-east corners of a cell.
+func geohashBounds(lonIdx, latIdx int64, precision int) (minLon, minLat, maxLon, maxLat float64) {
+	lonBits, latBits := geohashBits(precision)
+	lonStep := 360 / math.Ldexp(1, lonBits)
+	latStep := 180 / math.Ldexp(1, latBits)
+	minLon = float64(lonIdx)*lonStep - 180
+	minLat = float64(latIdx)*latStep - 90
+	return minLon, minLat, minLon + lonStep, minLat + latStep
+}
+
+// GetHeatmap aggregates a metric into a geohash grid over the GNSS
+// positions of the selected vehicles. Every cell is a GeoJSON Feature with
+// its geohash, centroid, sample count and the average and maximum of the
+// metric, as a Point at the centroid (heat layers) or the cell Polygon.
+func GetHeatmap(c *gin.Context, pool *pgxpool.Pool) {
+	filters, valid := parseQueryFilters(c)
+	if !valid {
+		slog.Warn("invalid heatmap request params", "vehicle", c.Query("vehicle_id"), "fleet", c.Query("fleet"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
+		return
+	}
+
+	metric := c.DefaultQuery("metric", "speed")
+	m, err := lookupMetric(metric)
+	if err != nil {
+		slog.Warn("invalid heatmap params", "metric", metric, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric is not valid"})
+		return
+	}
+	// Cells report the average and maximum, like a trend bucket
+	if !m.Aggregatable {
+		slog.Warn("invalid heatmap params", "metric", metric, "error", "not aggregatable")
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("metric %s cannot be averaged over a cell", m.ID)})
+		return
+	}
+
+	precision := defaultGeohashPrecision
+	if raw := c.Query("precision"); raw != "" {
+		if precision, err = strconv.Atoi(raw); err != nil || precision < 1 || precision > maxGeohashPrecision {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("precision must be between 1 and %d", maxGeohashPrecision)})
+			return
+		}
+	}
+
+	shape := c.DefaultQuery("shape", "point")
+	if shape != "point" && shape != "polygon" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shape must be point or polygon"})
+		return
+	}
+
+	slog.Info("handling heatmap request", "metric", metric, "vehicles", filters.label(),
+		"start", filters.Start, "end", filters.End, "precision", precision)
+
+	// Cells are grouped by their integer column and row, the geohash is
+	// encoded from those
+	lonBits, latBits := geohashBits(precision)
+	query := fmt.Sprintf(`
+		SELECT lon_idx, lat_idx, COUNT(*), AVG(value), MAX(value)
+		FROM (
+			SELECT
+				LEAST(floor((gnss_longitude + 180) / 360 * $4::float8), $4::float8 - 1)::bigint AS lon_idx,
+				LEAST(floor((gnss_latitude + 90) / 180 * $5::float8), $5::float8 - 1)::bigint AS lat_idx,
+				%s::float8 AS value
+			FROM telemetry
+			WHERE %s
+			  AND time_iso >= $2::timestamptz
+			  AND time_iso <= $3::timestamptz
+			  AND gnss_latitude BETWEEN -90 AND 90
+			  AND gnss_longitude BETWEEN -180 AND 180
+		) cells
+		GROUP BY lon_idx, lat_idx
+		ORDER BY lat_idx, lon_idx
+		LIMIT $6
+	`, m.Column, vehicleCondition)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := pool.Query(ctx, query, filters.vehicleArg(), filters.Start, filters.End,
+		math.Ldexp(1, lonBits), math.Ldexp(1, latBits), heatmapMaxCells+1)
+	if err != nil {
+		slog.Error("heatmap query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	defer rows.Close()
+
+	fc := newFeatureCollection()
+	var maxCount int64
+	var minAvg, maxAvg *float64
+	for rows.Next() {
+		if int64(len(fc.Features)) == heatmapMaxCells {
+			slog.Warn("heatmap exceeds cell budget", "precision", precision, "max", heatmapMaxCells)
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("heatmap has more than %d cells: use a lower precision or a shorter range", heatmapMaxCells)})
+			return
+		}
+		var lonIdx, latIdx, count int64
+		var avg, peak *float64
+		if err := rows.Scan(&lonIdx, &latIdx, &count, &avg, &peak); err != nil {
+			slog.Error("row scan failed inside heatmap", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
+			return
+		}
+
+		minLon, minLat, maxLon, maxLat := geohashBounds(lonIdx, latIdx, precision)
+		centroid := []float64{(minLon + maxLon) / 2, (minLat + maxLat) / 2}
+		props := map[string]interface{}{
+			"cell":     geohashCell(lonIdx, latIdx, precision),
+			"centroid": centroid,
+			"count":    count,
+			"avg":      avg,
+			"max":      peak,
+		}
+		if shape == "polygon" {
+			ring := [][]float64{{minLon, minLat}, {maxLon, minLat}, {maxLon, maxLat}, {minLon, maxLat}, {minLon, minLat}}
+			fc.Features = append(fc.Features, newFeature("Polygon", [][][]float64{ring}, props))
+		} else {
+			fc.Features = append(fc.Features, newFeature("Point", centroid, props))
+		}
+
+		maxCount = max(maxCount, count)
+		if avg != nil && (minAvg == nil || *avg < *minAvg) {
+			minAvg = avg
+		}
+		if avg != nil && (maxAvg == nil || *avg > *maxAvg) {
+			maxAvg = avg
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("heatmap query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	// Ranges for the weight and color scales of the map layer
+	fc.Properties = map[string]interface{}{
+		"metric":    metric,
+		"unit":      m.Unit,
+		"vehicle":   filters.label(),
+		"start":     filters.Start,
+		"end":       filters.End,
+		"grid":      "geohash",
+		"precision": precision,
+		"cells":     len(fc.Features),
+		"max_count": maxCount,
+		"min_avg":   minAvg,
+		"max_avg":   maxAvg,
+	}
+
+	slog.Info("heatmap computed", "metric", metric, "vehicles", filters.label(), "cells", len(fc.Features))
+	c.JSON(http.StatusOK, fc)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geohashIndex returns the grid cell of a position, as the /heatmap query
+// computes it.
+func geohashIndex(lat, lon float64, precision int) (lonIdx, latIdx int64) {
+	lonBits, latBits := geohashBits(precision)
+	lonCells, latCells := math.Ldexp(1, lonBits), math.Ldexp(1, latBits)
+	lonIdx = int64(math.Min(math.Floor((lon+180)/360*lonCells), lonCells-1))
+	latIdx = int64(math.Min(math.Floor((lat+90)/180*latCells), latCells-1))
+	return lonIdx, latIdx
+}
+
+func TestGeohashCell(t *testing.T) {
+	for _, c := range []struct {
+		lat, lon float64
+		want     string
+	}{
+		{57.64911, 10.40744, "u4pruydqq"},
+		{57.64911, 10.40744, "u4pru"},
+		{47.3769, 8.5417, "u0qjd2e"},
+		{47.3769, 8.5417, "u"},
+		{-90, -180, "0000"},
+		{90, 180, "zzzz"},
+		{0, 0, "s0"},
+		{-0.0001, -0.0001, "7z"},
+	} {
+		lonIdx, latIdx := geohashIndex(c.lat, c.lon, len(c.want))
+		if got := geohashCell(lonIdx, latIdx, len(c.want)); got != c.want {
+			t.Errorf("geohash of %g, %g = %q, want %q", c.lat, c.lon, got, c.want)
+		}
+	}
+}
+
+func TestGeohashBounds(t *testing.T) {
+	minLon, minLat, maxLon, maxLat := geohashBounds(16, 16, 2)
+	if minLon != 0 || minLat != 0 || maxLon != 11.25 || maxLat != 5.625 {
+		t.Errorf("cell s0 = [%g, %g, %g, %g]", minLon, minLat, maxLon, maxLat)
+	}
+	if minLon, minLat, _, _ := geohashBounds(7, 3, 1); minLon != 135 || minLat != 45 {
+		t.Errorf("cell z starts at %g, %g", minLon, minLat)
+	}
+
+	// Every cell contains the positions it is computed from
+	lat, lon := 47.3769, 8.5417
+	for precision := 1; precision <= maxGeohashPrecision; precision++ {
+		lonIdx, latIdx := geohashIndex(lat, lon, precision)
+		minLon, minLat, maxLon, maxLat := geohashBounds(lonIdx, latIdx, precision)
+		if lon < minLon || lon >= maxLon || lat < minLat || lat >= maxLat {
+			t.Errorf("precision %d: cell [%g, %g, %g, %g] does not contain Zurich",
+				precision, minLon, minLat, maxLon, maxLat)
+		}
+	}
+}
+
+func TestGetHeatmapParams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// Refused before the database is queried
+	r.GET("/heatmap", func(c *gin.Context) { GetHeatmap(c, nil) })
+
+	base := "/heatmap?vehicle_id=B183&start=2019-06-24T00:00:00Z&end=2019-06-25T00:00:00Z"
+	for query, want := range map[string]string{
+		"&metric=course":         "cannot be averaged",
+		"&metric=latitude":       "cannot be averaged",
+		"&metric=rpm":            "metric is not valid",
+		"&precision=10":          "precision",
+		"&precision=0":           "precision",
+		"&shape=hexagon":         "shape",
+		"&metric=speed&shape=12": "shape",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, base+query, nil))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s: %d %s, want 400 mentioning %q", query, w.Code, w.Body, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// uploadFormat is the container of an uploaded file, derived from its extension.
+type uploadFormat string
+
+const (
+	formatCSV  uploadFormat = "csv"
+	formatGzip uploadFormat = "gzip"
+	formatZstd uploadFormat = "zstd"
+	formatZip  uploadFormat = "zip"
+
+	formatParquet     uploadFormat = "parquet"
+	formatArrow       uploadFormat = "arrow"        // Arrow IPC file format
+	formatArrowStream uploadFormat = "arrow-stream" // Arrow IPC stream format
+)
+
+// detectUploadFormat maps a file name to its upload format.
+func detectUploadFormat(filename string) (uploadFormat, error) {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".csv"):
+		return formatCSV, nil
+	case strings.HasSuffix(name, ".csv.gz"):
+		return formatGzip, nil
+	case strings.HasSuffix(name, ".csv.zst"):
+		return formatZstd, nil
+	case strings.HasSuffix(name, ".zip"):
+		return formatZip, nil
+	case strings.HasSuffix(name, ".parquet"):
+		return formatParquet, nil
+	case strings.HasSuffix(name, ".arrow"), strings.HasSuffix(name, ".feather"):
+		return formatArrow, nil
+	case strings.HasSuffix(name, ".arrows"):
+		return formatArrowStream, nil
+	default:
+		return "", fmt.Errorf("invalid file extension, must be .csv, .csv.gz, .csv.zst, .zip, .parquet, .arrow or .arrows")
+	}
+}
+
+// csvName strips a compression suffix, so that vehicle and mission metadata
+// are parsed from the underlying CSV name.
+func csvName(filename string) string {
+	for _, ext := range []string{".gz", ".zst"} {
+		if strings.HasSuffix(strings.ToLower(filename), ext) {
+			return filename[:len(filename)-len(ext)]
+		}
+	}
+	return filename
+}
+
+// uploadEntry is one file stream inside an upload.
+type uploadEntry struct {
+	Name   string       // file name, used for vehicle and mission metadata
+	Format uploadFormat // format of the opened stream
+	open   func() (io.ReadCloser, error)
+}
+
+// openUploadEntries lists the file streams of a spooled upload. Plain,
+// compressed and columnar files yield a single entry, zip archives one per
+// CSV file. The
+// returned closer, if not nil, releases the archive once all entries are read.
+func openUploadEntries(spoolPath, filename string, format uploadFormat) ([]uploadEntry, io.Closer, error) {
+	if format == formatZip {
+		zr, err := zip.OpenReader(spoolPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open zip archive: %w", err)
+		}
+
+		var entries []uploadEntry
+		for _, f := range zr.File {
+			base := path.Base(f.Name)
+			if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+				continue
+			}
+			if !strings.HasSuffix(strings.ToLower(base), ".csv") {
+				continue
+			}
+			entries = append(entries, uploadEntry{Name: base, Format: formatCSV, open: f.Open})
+		}
+		if len(entries) == 0 {
+			zr.Close()
+			return nil, nil, fmt.Errorf("zip archive contains no .csv files")
+		}
+		return entries, zr, nil
+	}
+
+	entry := uploadEntry{
+		Name:   csvName(filename),
+		Format: format,
+		open:   func() (io.ReadCloser, error) { return openDecompressed(spoolPath, format) },
+	}
+	if format == formatGzip || format == formatZstd {
+		entry.Format = formatCSV
+	}
+	return []uploadEntry{entry}, nil, nil
+}
+
+// openDecompressed opens a spooled file and decompresses it as a stream.
+// Uncompressed files are returned as the *os.File itself.
+func openDecompressed(spoolPath string, format uploadFormat) (io.ReadCloser, error) {
+	f, err := os.Open(spoolPath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case formatGzip:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("open gzip stream: %w", err)
+		}
+		return readCloser{gz, func() error { gz.Close(); return f.Close() }}, nil
+	case formatZstd:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("open zstd stream: %w", err)
+		}
+		return readCloser{zr, func() error { zr.Close(); return f.Close() }}, nil
+	default:
+		return f, nil
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDetectUploadFormat(t *testing.T) {
+	for name, want := range map[string]uploadFormat{
+		"B183_2019-06-24.csv": formatCSV,
+		"B183.CSV":            formatCSV,
+		"B183.csv.gz":         formatGzip,
+		"B183.csv.zst":        formatZstd,
+		"missions.ZIP":        formatZip,
+		"B183.parquet":        formatParquet,
+		"B183.arrow":          formatArrow,
+		"B183.feather":        formatArrow,
+		"B183.arrows":         formatArrowStream,
+	} {
+		if got, err := detectUploadFormat(name); err != nil || got != want {
+			t.Errorf("detectUploadFormat(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"B183.gz", "B183.json", "B183.csv.bak", ""} {
+		if _, err := detectUploadFormat(name); err == nil {
+			t.Errorf("detectUploadFormat(%q) accepted the file", name)
+		}
+	}
+}
+
+func TestCSVName(t *testing.T) {
+	for in, want := range map[string]string{
+		"B183.csv":     "B183.csv",
+		"B183.csv.gz":  "B183.csv",
+		"B183.csv.ZST": "B183.csv",
+		"B183.zip":     "B183.zip",
+	} {
+		if got := csvName(in); got != want {
+			t.Errorf("csvName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// writeSpool writes content to a file in a test directory and returns its path.
+func writeSpool(t *testing.T, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readEntries returns the name and content of every entry of an upload.
+func readEntries(t *testing.T, spoolPath, filename string, format uploadFormat) map[string]string {
+	t.Helper()
+	entries, closer, err := openUploadEntries(spoolPath, filename, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	out := make(map[string]string)
+	for _, e := range entries {
+		r, err := e.open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", e.Name, err)
+		}
+		out[e.Name] = string(b)
+	}
+	return out
+}
+
+func TestOpenUploadEntries(t *testing.T) {
+	const csv = "time_iso,odometry_vehicleSpeed\n2019-06-24T03:16:13Z,8.25\n"
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(csv))
+	zw.Close()
+	got := readEntries(t, writeSpool(t, gz.Bytes()), "B183.csv.gz", formatGzip)
+	if len(got) != 1 || got["B183.csv"] != csv {
+		t.Errorf("gzip entries = %q", got)
+	}
+
+	enc, _ := zstd.NewWriter(nil)
+	zst := enc.EncodeAll([]byte(csv), nil)
+	got = readEntries(t, writeSpool(t, zst), "B183.csv.zst", formatZstd)
+	if len(got) != 1 || got["B183.csv"] != csv {
+		t.Errorf("zstd entries = %q", got)
+	}
+
+	// Directories, hidden files, macOS metadata and other files are skipped
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	for _, name := range []string{"day1/B183_a.csv", "B208_b.CSV", "notes.txt", ".hidden.csv", "__MACOSX/day1/._B183_a.csv", "day2/"} {
+		f, _ := w.Create(name)
+		f.Write([]byte(csv))
+	}
+	w.Close()
+	got = readEntries(t, writeSpool(t, archive.Bytes()), "missions.zip", formatZip)
+	if len(got) != 2 || got["B183_a.csv"] != csv || got["B208_b.CSV"] != csv {
+		t.Errorf("zip entries = %q, want B183_a.csv and B208_b.CSV", got)
+	}
+}
+
+func TestOpenUploadEntriesErrors(t *testing.T) {
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	f, _ := w.Create("readme.txt")
+	f.Write([]byte("no data"))
+	w.Close()
+	if _, _, err := openUploadEntries(writeSpool(t, archive.Bytes()), "empty.zip", formatZip); err == nil {
+		t.Error("zip without CSV files was accepted")
+	}
+	if _, _, err := openUploadEntries(writeSpool(t, []byte("plain text")), "bad.zip", formatZip); err == nil {
+		t.Error("invalid zip was accepted")
+	}
+
+	entries, _, err := openUploadEntries(writeSpool(t, []byte("plain text")), "bad.csv.gz", formatGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entries[0].open(); err == nil {
+		t.Error("invalid gzip stream was opened")
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// spooledUpload is one uploaded file part, stored on disk until its job ends.
+type spooledUpload struct {
+	filename string
+	format   uploadFormat
+	path     string
+}
+
+// IngestFileResult is the outcome of one file of a batch.
+type IngestFileResult struct {
+	Filename      string `json:"filename"`
+	Upload        string `json:"upload,omitempty"` // archive the file came from
+	VehicleID     string `json:"vehicle_id,omitempty"`
+	VehicleSource string `json:"vehicle_source,omitempty"`
+	ingestResult
+	// RolledBack is set on files of an atomic batch that was rolled back
+	// because of another file or the commit; Error then says why, if the
+	// file was never processed.
+	RolledBack bool   `json:"rolled_back,omitempty"`
+	Error      string `json:"error,omitempty"`
+
+	rows int64 // rows read, counted in the job's rows_processed
+}
+
+// rollBack marks a file whose rows were rolled back with the batch: its
+// counts are zeroed and its rows no longer count as processed.
+func (r *IngestFileResult) rollBack(progress *ingestProgress) {
+	progress.rows.Add(-r.rows)
+	r.rows = 0
+	r.ingestResult = ingestResult{}
+	r.RolledBack = true
+}
+
+// batchEntry is a file stream together with the upload it belongs to.
+type batchEntry struct {
+	upload spooledUpload
+	entry  uploadEntry
+}
+
+// ingestBatch ingests every file of the given uploads and returns one
+// result per file. Without opts.Atomic each file gets its own transaction;
+// with it all files share one and the first failure rolls back the batch.
+func ingestBatch(pool *pgxpool.Pool, uploads []spooledUpload, opts ingestOptions, progress *ingestProgress) []IngestFileResult {
+	var entries []batchEntry
+	var results []IngestFileResult
+	openFailed := false
+
+	for _, u := range uploads {
+		files, closer, err := openUploadEntries(u.path, u.filename, u.format)
+		if err != nil {
+			results = append(results, IngestFileResult{Filename: u.filename, Error: err.Error()})
+			openFailed = true
+			continue
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		for _, f := range files {
+			entries = append(entries, batchEntry{upload: u, entry: f})
+		}
+	}
+
+	if !opts.Atomic {
+		for _, e := range entries {
+			results = append(results, ingestEntry(e, opts, progress, func(r io.Reader, meta uploadMeta) (ingestResult, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), ingestTimeout)
+				defer cancel()
+				return ingestUpload(ctx, pool, r, meta, opts, progress)
+			}))
+		}
+		return results
+	}
+
+	if openFailed {
+		return append(results, abortedResults(entries, "not processed")...)
+	}
+	return ingestAtomic(pool, entries, opts, progress)
+}
+
+// ingestAtomic ingests all entries inside a single transaction.
+func ingestAtomic(pool *pgxpool.Pool, entries []batchEntry, opts ingestOptions, progress *ingestProgress) []IngestFileResult {
+	ctx, cancel := context.WithTimeout(context.Background(), ingestTimeout)
+	defer cancel()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return abortedResults(entries, fmt.Sprintf("db begin: %v", err))
+	}
+	defer tx.Rollback(ctx)
+
+	results := make([]IngestFileResult, 0, len(entries))
+	for i, e := range entries {
+		res := ingestEntry(e, opts, progress, func(r io.Reader, meta uploadMeta) (ingestResult, error) {
+			return ingestUploadTx(ctx, tx, r, meta, opts, progress)
+		})
+		results = append(results, res)
+
+		if res.Error != "" {
+			// A failed COPY aborts the transaction, nothing else can run in it
+			for j := range results[:i] {
+				results[j].rollBack(progress)
+			}
+			return append(results, abortedResults(entries[i+1:], "not processed")...)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("batch commit failed", "error", err)
+		for i := range results {
+			results[i].rollBack(progress)
+			results[i].Error = "commit failed: " + err.Error()
+		}
+	}
+	return results
+}
+
+// ingestEntry resolves the metadata of one file and runs ingest on it. The
+// rows of a failed file, whose transaction is rolled back, are taken out of
+// the job's progress again.
+func ingestEntry(e batchEntry, opts ingestOptions, progress *ingestProgress, ingest func(io.Reader, uploadMeta) (ingestResult, error)) IngestFileResult {
+	out := IngestFileResult{Filename: e.entry.Name}
+	if e.upload.format == formatZip {
+		out.Upload = e.upload.filename
+	}
+
+	vehicleID, vehicleSource, err := resolveVehicleID(opts.VehicleID, e.entry.Name, opts.Profile)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	out.VehicleID, out.VehicleSource = vehicleID, vehicleSource
+
+	meta := uploadMeta{Filename: e.entry.Name, Format: e.entry.Format, VehicleID: vehicleID, VehicleSource: vehicleSource}
+	meta.DeclaredStart, meta.DeclaredEnd = declaredMissionRange(e.entry.Name, opts.Profile)
+
+	r, err := e.entry.open()
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	defer r.Close()
+
+	before := progress.rows.Load()
+	res, err := ingest(r, meta)
+	out.rows = progress.rows.Load() - before
+	if err != nil {
+		progress.rows.Add(-out.rows)
+		out.rows = 0
+		out.Error = err.Error()
+		return out
+	}
+	out.ingestResult = res
+	return out
+}
+
+func abortedResults(entries []batchEntry, reason string) []IngestFileResult {
+	out := make([]IngestFileResult, 0, len(entries))
+	for _, e := range entries {
+		res := IngestFileResult{Filename: e.entry.Name, RolledBack: true, Error: reason}
+		if e.upload.format == formatZip {
+			res.Upload = e.upload.filename
+		}
+		out = append(out, res)
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stringEntry(name, content string) uploadEntry {
+	return uploadEntry{Name: name, open: func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(content)), nil
+	}}
+}
+
+func TestIngestEntry(t *testing.T) {
+	archive := spooledUpload{filename: "fleet.zip", format: formatZip}
+	e := batchEntry{upload: archive, entry: stringEntry("B183_2019-06-24_03-16-13_2019-06-24_04-45-00.csv", "time_iso\n")}
+
+	var gotMeta uploadMeta
+	var gotBody string
+	res := ingestEntry(e, ingestOptions{Profile: ztbusProfile}, &ingestProgress{}, func(r io.Reader, meta uploadMeta) (ingestResult, error) {
+		b, _ := io.ReadAll(r)
+		gotMeta, gotBody = meta, string(b)
+		return ingestResult{Inserted: 7}, nil
+	})
+
+	if res.Error != "" || res.Inserted != 7 {
+		t.Fatalf("result = %+v", res)
+	}
+	if res.Upload != "fleet.zip" || res.VehicleID != "B183" || res.VehicleSource != vehicleFromFilename {
+		t.Errorf("result = %+v", res)
+	}
+	if gotBody != "time_iso\n" || gotMeta.VehicleID != "B183" || gotMeta.DeclaredStart == nil || gotMeta.DeclaredEnd == nil {
+		t.Errorf("ingest got meta %+v, body %q", gotMeta, gotBody)
+	}
+}
+
+func TestIngestEntryErrors(t *testing.T) {
+	called := false
+	ok := func(io.Reader, uploadMeta) (ingestResult, error) { called = true; return ingestResult{}, nil }
+
+	// No vehicle in the form or the name: ingest must not run
+	res := ingestEntry(batchEntry{entry: stringEntry("unknown.csv", "")}, ingestOptions{Profile: ztbusProfile}, &ingestProgress{}, ok)
+	if res.Error == "" || called || res.Upload != "" {
+		t.Errorf("unresolved vehicle: %+v, called %v", res, called)
+	}
+
+	form := ingestOptions{Profile: ztbusProfile, VehicleID: "B183"}
+	broken := uploadEntry{Name: "B183.csv", open: func() (io.ReadCloser, error) { return nil, errors.New("corrupt entry") }}
+	res = ingestEntry(batchEntry{entry: broken}, form, &ingestProgress{}, ok)
+	if res.Error != "corrupt entry" || called {
+		t.Errorf("open failure: %+v, called %v", res, called)
+	}
+
+	// Rows read before the failure were rolled back and are not processed
+	progress := &ingestProgress{}
+	progress.rows.Store(10)
+	res = ingestEntry(batchEntry{entry: stringEntry("B183.csv", "")}, form, progress,
+		func(io.Reader, uploadMeta) (ingestResult, error) {
+			progress.rows.Add(5)
+			return ingestResult{}, errors.New("copy failed")
+		})
+	if res.Error != "copy failed" || res.VehicleID != "B183" || res.rows != 0 || progress.rows.Load() != 10 {
+		t.Errorf("ingest failure: %+v, %d rows processed", res, progress.rows.Load())
+	}
+}
+
+func TestRollBack(t *testing.T) {
+	progress := &ingestProgress{}
+	progress.rows.Store(10)
+	res := ingestEntry(batchEntry{entry: stringEntry("B183.csv", "")}, ingestOptions{Profile: ztbusProfile, VehicleID: "B183"}, progress,
+		func(io.Reader, uploadMeta) (ingestResult, error) {
+			progress.rows.Add(4)
+			return ingestResult{Inserted: 3, Skipped: 1}, nil
+		})
+	if res.rows != 4 || progress.rows.Load() != 14 {
+		t.Fatalf("ingested file counts %d rows, %d processed", res.rows, progress.rows.Load())
+	}
+
+	res.rollBack(progress)
+	if !res.RolledBack || !reflect.DeepEqual(res.ingestResult, ingestResult{}) || progress.rows.Load() != 10 {
+		t.Errorf("rolled back: %+v, %d rows processed", res, progress.rows.Load())
+	}
+}
+
+func TestIngestAtomic(t *testing.T) {
+	pool := testPool(t)
+	good := ztbusHeader + "\n" + ztbusRow + "\n"
+	bad := ztbusHeader + "\n" + strings.Replace(ztbusRow, "8.25", "fast", 1) + "\n"
+	upload := spooledUpload{filename: "B183.csv", format: formatCSV}
+	entries := []batchEntry{
+		{upload: upload, entry: stringEntry("first.csv", good)},
+		{upload: upload, entry: stringEntry("second.csv", bad)},
+		{upload: upload, entry: stringEntry("third.csv", good)},
+	}
+
+	progress := &ingestProgress{}
+	results := ingestAtomic(pool, entries, ingestOptions{Conflict: conflictSkip, Profile: ztbusProfile, VehicleID: "TATOMIC"}, progress)
+	if len(results) != 3 {
+		t.Fatalf("%d results", len(results))
+	}
+	if first := results[0]; !first.RolledBack || first.Inserted != 0 || first.Error != "" {
+		t.Errorf("first file = %+v, want rolled back without counts", first)
+	}
+	if results[1].Error == "" || results[2].Error != "not processed" {
+		t.Errorf("errors = %q, %q", results[1].Error, results[2].Error)
+	}
+	if n := progress.rows.Load(); n != 0 {
+		t.Errorf("%d rows processed, want 0", n)
+	}
+
+	var stored int
+	if err := pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM telemetry WHERE vehicle_id = 'TATOMIC'").Scan(&stored); err != nil || stored != 0 {
+		t.Errorf("%d rows stored, %v", stored, err)
+	}
+}
+
+func TestAbortedResults(t *testing.T) {
+	entries := []batchEntry{
+		{upload: spooledUpload{filename: "B183.csv", format: formatCSV}, entry: stringEntry("B183.csv", "")},
+		{upload: spooledUpload{filename: "fleet.zip", format: formatZip}, entry: stringEntry("B208.csv", "")},
+	}
+	got := abortedResults(entries, "not processed")
+	if len(got) != 2 {
+		t.Fatalf("got %d results", len(got))
+	}
+	for i, want := range []IngestFileResult{
+		{Filename: "B183.csv", RolledBack: true, Error: "not processed"},
+		{Filename: "B208.csv", Upload: "fleet.zip", RolledBack: true, Error: "not processed"},
+	} {
+		if !reflect.DeepEqual(got[i], want) {
+			t.Errorf("result %d = %+v, want %+v", i, got[i], want)
+		}
+	}
+}
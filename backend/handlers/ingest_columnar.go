@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/jackc/pgx/v5"
+)
+
+// Rows per record batch read from a Parquet file.
+const parquetBatchSize = 64 * 1024
+
+// seekableFile is a spooled upload that columnar readers can seek in.
+type seekableFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// ingestColumnarTx streams a Parquet or Arrow IPC file into the telemetry
+// table within tx, see ingestRecordsTx. Column names are mapped through the
+// profile like CSV headers; typed values are kept as they are.
+func ingestColumnarTx(ctx context.Context, tx pgx.Tx, f seekableFile, meta uploadMeta, opts ingestOptions, progress *ingestProgress) (ingestResult, error) {
+	// Columnar readers seek around, so the checksum is taken up front
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return ingestResult{}, fmt.Errorf("checksum %s file: %w", meta.Format, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return ingestResult{}, err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	batches, err := openRecordBatches(ctx, f, meta.Format)
+	if err != nil {
+		return ingestResult{}, err
+	}
+	defer batches.Release()
+
+	schema := batches.Schema()
+	header := make([]string, schema.NumFields())
+	for i, field := range schema.Fields() {
+		header[i] = field.Name
+	}
+
+	rr := &columnarRecordReader{batches: batches}
+	return ingestRecordsTx(ctx, tx, rr, header, func() string { return checksum }, meta, opts, progress)
+}
+
+// openRecordBatches opens a columnar file as a stream of record batches.
+func openRecordBatches(ctx context.Context, f seekableFile, format uploadFormat) (array.RecordReader, error) {
+	switch format {
+	case formatParquet:
+		pf, err := file.NewParquetReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("open parquet file: %w", err)
+		}
+		fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: parquetBatchSize}, memory.DefaultAllocator)
+		if err != nil {
+			pf.Close()
+			return nil, fmt.Errorf("open parquet file: %w", err)
+		}
+		rr, err := fr.GetRecordReader(ctx, nil, nil)
+		if err != nil {
+			pf.Close()
+			return nil, fmt.Errorf("read parquet file: %w", err)
+		}
+		return rr, nil
+
+	case formatArrow:
+		fr, err := ipc.NewFileReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("open arrow file: %w", err)
+		}
+		return &arrowFileBatches{reader: fr}, nil
+
+	case formatArrowStream:
+		r, err := ipc.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("open arrow stream: %w", err)
+		}
+		return r, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported columnar format: %s", format)
+	}
+}
+
+// arrowFileBatches iterates the record batches of an Arrow IPC file.
+type arrowFileBatches struct {
+	reader *ipc.FileReader
+	next   int
+	batch  arrow.RecordBatch
+	err    error
+}
+
+func (b *arrowFileBatches) Retain() {}
+
+func (b *arrowFileBatches) Release() { b.reader.Close() }
+
+func (b *arrowFileBatches) Schema() *arrow.Schema { return b.reader.Schema() }
+
+func (b *arrowFileBatches) Next() bool {
+	if b.err != nil || b.next >= b.reader.NumRecords() {
+		return false
+	}
+	b.batch, b.err = b.reader.RecordBatch(b.next)
+	b.next++
+	return b.err == nil
+}
+
+func (b *arrowFileBatches) RecordBatch() arrow.RecordBatch { return b.batch }
+
+func (b *arrowFileBatches) Record() arrow.RecordBatch { return b.batch }
+
+func (b *arrowFileBatches) Err() error { return b.err }
+
+// columnarRecordReader flattens record batches into records of typed cells.
+type columnarRecordReader struct {
+	batches array.RecordReader
+	batch   arrow.RecordBatch
+	cells   []func(i int) interface{} // one per column of the current batch
+	pos     int                       // row within the current batch
+	row     int                       // row within the file
+	record  []interface{}
+}
+
+func (r *columnarRecordReader) Read() ([]interface{}, int, error) {
+	for r.batch == nil || r.pos >= int(r.batch.NumRows()) {
+		if !r.batches.Next() {
+			if err := r.batches.Err(); err != nil && err != io.EOF {
+				return nil, 0, err
+			}
+			return nil, 0, io.EOF
+		}
+		r.batch = r.batches.RecordBatch()
+		r.pos = 0
+		r.cells = r.cells[:0]
+		for _, col := range r.batch.Columns() {
+			r.cells = append(r.cells, cellReader(col))
+		}
+	}
+
+	r.record = r.record[:0]
+	for _, cell := range r.cells {
+		r.record = append(r.record, cell(r.pos))
+	}
+	r.pos++
+	r.row++
+	return r.record, r.row, nil
+}
+
+// cellReader returns a function reading one value of col as a Go value.
+// Types without a direct mapping are read as text and parsed like CSV cells.
+// Uint64 values past the int64 range are read as float64, which integer
+// columns reject.
+func cellReader(col arrow.Array) func(i int) interface{} {
+	var get func(i int) interface{}
+	switch a := col.(type) {
+	case *array.Float64:
+		get = func(i int) interface{} { return a.Value(i) }
+	case *array.Float32:
+		get = func(i int) interface{} { return float64(a.Value(i)) }
+	case *array.Int64:
+		get = func(i int) interface{} { return a.Value(i) }
+	case *array.Int32:
+		get = func(i int) interface{} { return int64(a.Value(i)) }
+	case *array.Int16:
+		get = func(i int) interface{} { return int64(a.Value(i)) }
+	case *array.Int8:
+		get = func(i int) interface{} { return int64(a.Value(i)) }
+	case *array.Uint64:
+		get = func(i int) interface{} {
+			v := a.Value(i)
+			if v > math.MaxInt64 {
+				return float64(v)
+			}
+			return int64(v)
+		}
+	case *array.Uint32:
+		get = func(i int) interface{} { return int64(a.Value(i)) }
+	case *array.Uint16:
+		get = func(i int) interface{} { return int64(a.Value(i)) }
+	case *array.Uint8:
+		get = func(i int) interface{} { return int64(a.Value(i)) }
+	case *array.Boolean:
+		get = func(i int) interface{} { return a.Value(i) }
+	case *array.String:
+		get = func(i int) interface{} { return a.Value(i) }
+	case *array.LargeString:
+		get = func(i int) interface{} { return a.Value(i) }
+	case *array.Timestamp:
+		unit := a.DataType().(*arrow.TimestampType).Unit
+		get = func(i int) interface{} { return a.Value(i).ToTime(unit) }
+	case *array.Date32:
+		get = func(i int) interface{} { return a.Value(i).ToTime() }
+	case *array.Date64:
+		get = func(i int) interface{} { return a.Value(i).ToTime() }
+	default:
+		get = func(i int) interface{} { return col.ValueStr(i) }
+	}
+
+	return func(i int) interface{} {
+		if col.IsNull(i) {
+			return nil
+		}
+		return get(i)
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// telemetryBatch builds a two-row batch with a null speed in the second row.
+func telemetryBatch(t *testing.T) arrow.RecordBatch {
+	t.Helper()
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "time_iso", Type: &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}},
+		{Name: "odometry_vehicleSpeed", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+		{Name: "status_doorIsOpen", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "itcs_numberOfPassengers", Type: arrow.PrimitiveTypes.Int16},
+	}, nil)
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	start := time.Date(2019, 6, 24, 3, 16, 13, 0, time.UTC)
+	b.Field(0).(*array.TimestampBuilder).AppendValues([]arrow.Timestamp{
+		arrow.Timestamp(start.UnixMilli()), arrow.Timestamp(start.Add(time.Second).UnixMilli()),
+	}, nil)
+	b.Field(1).(*array.Float32Builder).AppendValues([]float32{8.25, 0}, []bool{true, false})
+	b.Field(2).(*array.BooleanBuilder).AppendValues([]bool{true, false}, nil)
+	b.Field(3).(*array.Int16Builder).AppendValues([]int16{12, 13}, nil)
+	return b.NewRecordBatch()
+}
+
+func readAllRecords(t *testing.T, f seekableFile, format uploadFormat) [][]interface{} {
+	t.Helper()
+	batches, err := openRecordBatches(context.Background(), f, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batches.Release()
+
+	rr := &columnarRecordReader{batches: batches}
+	var out [][]interface{}
+	for {
+		rec, row, err := rr.Read()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row != len(out)+1 {
+			t.Errorf("row number %d, want %d", row, len(out)+1)
+		}
+		out = append(out, append([]interface{}(nil), rec...))
+	}
+}
+
+func TestColumnarRecordReader(t *testing.T) {
+	batch := telemetryBatch(t)
+	defer batch.Release()
+
+	var arrowFile, arrowStream, parquetFile bytes.Buffer
+	fw, err := ipc.NewFileWriter(&arrowFile, ipc.WithSchema(batch.Schema()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw := ipc.NewWriter(&arrowStream, ipc.WithSchema(batch.Schema()))
+	for _, w := range []interface {
+		Write(arrow.RecordBatch) error
+		Close() error
+	}{fw, sw} {
+		if err := w.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table := array.NewTableFromRecords(batch.Schema(), []arrow.RecordBatch{batch})
+	defer table.Release()
+	if err := pqarrow.WriteTable(table, &parquetFile, 1024, nil, pqarrow.DefaultWriterProps()); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2019, 6, 24, 3, 16, 13, 0, time.UTC)
+	want := [][]interface{}{
+		{start, 8.25, true, int64(12)},
+		{start.Add(time.Second), nil, false, int64(13)},
+	}
+	for format, buf := range map[uploadFormat]*bytes.Buffer{
+		formatArrow:       &arrowFile,
+		formatArrowStream: &arrowStream,
+		formatParquet:     &parquetFile,
+	} {
+		got := readAllRecords(t, bytes.NewReader(buf.Bytes()), format)
+		for _, rec := range got {
+			rec[0] = rec[0].(time.Time).UTC()
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: records = %v, want %v", format, got, want)
+		}
+	}
+
+	if _, err := openRecordBatches(context.Background(), bytes.NewReader([]byte("time_iso\n")), formatParquet); err == nil {
+		t.Error("CSV content opened as parquet")
+	}
+}
+
+func TestConvertTyped(t *testing.T) {
+	profile := &MappingProfile{Name: "typed", Columns: map[string]string{
+		"time_iso": "time_iso", "time_unix": "time_unix", "speed": "odometry_vehicle_speed",
+		"door": "status_door_is_open", "stop": "itcs_stop_name",
+	}}
+	layout, err := newColumnLayout(profile, []string{"time_iso", "time_unix", "speed", "door", "stop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2019, 6, 24, 3, 16, 13, 0, time.UTC)
+
+	row, err := layout.parseRecord([]interface{}{ts, 1561346173.0, int64(8), true, int64(42)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{ts, int64(1561346173), 8.0, 1, "42"}; !reflect.DeepEqual(row, want) {
+		t.Errorf("row = %#v, want %#v", row, want)
+	}
+
+	// Text cells are parsed like CSV cells
+	if _, err := layout.parseRecord([]interface{}{"2019-06-24T03:16:13Z", int64(1), 1.0, false, nil}); err != nil {
+		t.Errorf("text time: %v", err)
+	}
+	for name, rec := range map[string][]interface{}{
+		"fractional int": {ts, 1.5, 1.0, false, nil},
+		"int past int64": {ts, float64(math.MaxUint64), 1.0, false, nil},
+		"time in float":  {ts, int64(1), ts, false, nil},
+		"missing time":   {nil, int64(1), 1.0, false, nil},
+	} {
+		if _, err := layout.parseRecord(rec); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestUnsignedParquetColumns(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "time_unix", Type: arrow.PrimitiveTypes.Uint64, Nullable: true},
+		{Name: "itcs_numberOfPassengers", Type: arrow.PrimitiveTypes.Uint32},
+	}, nil)
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	b.Field(0).(*array.Uint64Builder).AppendValues([]uint64{1561346173, math.MaxUint64, 0}, []bool{true, true, false})
+	b.Field(1).(*array.Uint32Builder).AppendValues([]uint32{12, math.MaxUint32, 0}, nil)
+	batch := b.NewRecordBatch()
+	defer batch.Release()
+
+	var buf bytes.Buffer
+	table := array.NewTableFromRecords(schema, []arrow.RecordBatch{batch})
+	defer table.Release()
+	if err := pqarrow.WriteTable(table, &buf, 1024, nil, pqarrow.DefaultWriterProps()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAllRecords(t, bytes.NewReader(buf.Bytes()), formatParquet)
+	want := [][]interface{}{
+		{int64(1561346173), int64(12)},
+		{float64(math.MaxUint64), int64(math.MaxUint32)},
+		{nil, int64(0)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+}
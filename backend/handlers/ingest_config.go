@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Ingest settings, overridable through environment variables.
+var (
+	// INGEST_MAX_UPLOAD_BYTES caps the size of a single upload request.
+	maxUploadBytes = envInt64("INGEST_MAX_UPLOAD_BYTES", 1<<30)
+	// INGEST_TIMEOUT bounds how long a single ingest may run, e.g. "10m".
+	ingestTimeout = envDuration("INGEST_TIMEOUT", 10*time.Minute)
+	// INGEST_MAX_RECORDS caps the number of records of a single POST /ingest.
+	maxIngestRecords = envInt64("INGEST_MAX_RECORDS", 10000)
+	// INGEST_MAX_RECORD_BYTES caps the body size of a single POST /ingest.
+	maxRecordBodyBytes = envInt64("INGEST_MAX_RECORD_BYTES", 16<<20)
+)
+
+func envInt64(key string, fallback int64) int64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || v <= 0 {
+		slog.Warn("invalid env value, using default", "key", key, "value", raw, "default", fallback)
+		return fallback
+	}
+	return v
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v <= 0 {
+		slog.Warn("invalid env value, using default", "key", key, "value", raw, "default", fallback)
+		return fallback
+	}
+	return v
+}
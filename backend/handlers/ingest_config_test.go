@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnvInt64(t *testing.T) {
+	for raw, want := range map[string]int64{
+		"":      42, // unset
+		"1024":  1024,
+		"0":     42,
+		"-5":    42,
+		"1MB":   42,
+		"1e6":   42,
+		" 1024": 42,
+	} {
+		t.Setenv("TEST_INGEST_LIMIT", raw)
+		if got := envInt64("TEST_INGEST_LIMIT", 42); got != want {
+			t.Errorf("envInt64(%q) = %d, want %d", raw, got, want)
+		}
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	for raw, want := range map[string]time.Duration{
+		"":      time.Minute,
+		"90s":   90 * time.Second,
+		"1h30m": 90 * time.Minute,
+		"0s":    time.Minute,
+		"-1m":   time.Minute,
+		"10":    time.Minute, // no unit
+	} {
+		t.Setenv("TEST_INGEST_TIMEOUT", raw)
+		if got := envDuration("TEST_INGEST_TIMEOUT", time.Minute); got != want {
+			t.Errorf("envDuration(%q) = %s, want %s", raw, got, want)
+		}
+	}
+}
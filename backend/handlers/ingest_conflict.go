@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// conflictMode decides what happens to rows whose (vehicle_id, time_iso)
+// already exists in telemetry.
+type conflictMode string
+
+const (
+	conflictError     conflictMode = "error"     // fail the whole ingest (plain COPY)
+	conflictSkip      conflictMode = "skip"      // keep existing rows
+	conflictOverwrite conflictMode = "overwrite" // replace existing rows
+)
+
+func parseConflictMode(s string) (conflictMode, error) {
+	switch mode := conflictMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return conflictError, nil
+	case conflictError, conflictSkip, conflictOverwrite:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid conflict mode: %s (must be error, skip or overwrite)", s)
+	}
+}
+
+// ingestResult reports what an ingest did with the rows it read.
+type ingestResult struct {
+	Inserted   int64   `json:"inserted"`
+	Skipped    int64   `json:"skipped"`
+	Updated    int64   `json:"updated"`
+	MissionIDs []int64 `json:"mission_ids,omitempty"`
+
+	// written counts the inserted and updated rows per vehicle; nil when
+	// every row read was inserted.
+	written map[string]int64
+}
+
+// copyTelemetry writes rows from src into telemetry inside tx according to
+// mode. In error mode rows are copied straight into the hypertable; otherwise
+// they are copied into a temporary staging table and merged with
+// INSERT ... ON CONFLICT.
+func copyTelemetry(ctx context.Context, tx pgx.Tx, cols []string, src pgx.CopyFromSource, mode conflictMode) (ingestResult, error) {
+	if mode == conflictError {
+		inserted, err := tx.CopyFrom(ctx, pgx.Identifier{"telemetry"}, cols, src)
+		return ingestResult{Inserted: inserted}, err
+	}
+
+	// seq numbers the rows in file order, COPY assigns it as it reads
+	_, err := tx.Exec(ctx, `
+		CREATE TEMP TABLE telemetry_staging
+		(LIKE telemetry INCLUDING DEFAULTS, seq BIGINT GENERATED ALWAYS AS IDENTITY)
+		ON COMMIT DROP
+	`)
+	if err != nil {
+		return ingestResult{}, fmt.Errorf("create staging table: %w", err)
+	}
+
+	staged, err := tx.CopyFrom(ctx, pgx.Identifier{"telemetry_staging"}, cols, src)
+	if err != nil {
+		return ingestResult{}, err
+	}
+
+	colList := strings.Join(cols, ", ")
+
+	// DISTINCT ON drops duplicate keys within the file itself, which
+	// ON CONFLICT DO UPDATE would otherwise reject. The last occurrence in
+	// the file wins.
+	onConflict := "DO NOTHING"
+	if mode == conflictOverwrite {
+		updates := make([]string, 0, len(cols))
+		for _, col := range cols {
+			if col == "vehicle_id" || col == "time_iso" {
+				continue
+			}
+			updates = append(updates, fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", col))
+		}
+		// Nothing to overwrite when only the key columns are mapped
+		if len(updates) > 0 {
+			onConflict = "DO UPDATE SET " + strings.Join(updates, ", ")
+		}
+	}
+
+	// xmax = 0 only holds for freshly inserted tuples, which separates
+	// inserts from updates in a single statement.
+	mergeQuery := fmt.Sprintf(`
+		WITH merged AS (
+			INSERT INTO telemetry (%[1]s)
+			SELECT DISTINCT ON (vehicle_id, time_iso) %[1]s
+			FROM telemetry_staging
+			ORDER BY vehicle_id, time_iso, seq DESC
+			ON CONFLICT (vehicle_id, time_iso) %[2]s
+			RETURNING vehicle_id, (xmax = 0) AS inserted
+		)
+		SELECT vehicle_id, COUNT(*) FILTER (WHERE inserted), COUNT(*) FILTER (WHERE NOT inserted)
+		FROM merged
+		GROUP BY vehicle_id
+	`, colList, onConflict)
+
+	res := ingestResult{written: make(map[string]int64)}
+	rows, err := tx.Query(ctx, mergeQuery)
+	if err != nil {
+		return ingestResult{}, fmt.Errorf("merge staged rows: %w", err)
+	}
+	for rows.Next() {
+		var vehicle string
+		var inserted, updated int64
+		if err := rows.Scan(&vehicle, &inserted, &updated); err != nil {
+			rows.Close()
+			return ingestResult{}, fmt.Errorf("merge staged rows: %w", err)
+		}
+		res.Inserted += inserted
+		res.Updated += updated
+		res.written[vehicle] = inserted + updated
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return ingestResult{}, fmt.Errorf("merge staged rows: %w", err)
+	}
+	res.Skipped = staged - res.Inserted - res.Updated
+
+	// Drop the staging table right away so that further files of an
+	// atomic batch can stage in the same transaction.
+	if _, err := tx.Exec(ctx, "DROP TABLE telemetry_staging"); err != nil {
+		return ingestResult{}, fmt.Errorf("drop staging table: %w", err)
+	}
+
+	return res, nil
+}
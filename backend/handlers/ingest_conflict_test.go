@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestParseConflictMode(t *testing.T) {
+	valid := map[string]conflictMode{
+		"":            conflictError, // default keeps the plain COPY
+		"error":       conflictError,
+		"skip":        conflictSkip,
+		"SKIP":        conflictSkip,
+		" overwrite ": conflictOverwrite,
+	}
+	for in, want := range valid {
+		if got, err := parseConflictMode(in); err != nil || got != want {
+			t.Errorf("parseConflictMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"replace", "ignore", "skip,overwrite"} {
+		if _, err := parseConflictMode(in); err == nil {
+			t.Errorf("parseConflictMode(%q) accepted an invalid mode", in)
+		}
+	}
+}
+
+func TestCopyTelemetryDuplicates(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	ts := time.Date(2019, 6, 24, 3, 16, 13, 0, time.UTC)
+	cols := []string{"vehicle_id", "time_iso", "odometry_vehicle_speed"}
+	res, err := copyTelemetry(ctx, tx, cols, pgx.CopyFromRows([][]interface{}{
+		{"T_conflict", ts, 1.0},
+		{"T_conflict", ts, 2.0},
+	}), conflictOverwrite)
+	if err != nil || res.Inserted != 1 || res.Skipped != 1 || res.written["T_conflict"] != 1 {
+		t.Fatalf("in-file duplicate: %+v, %v", res, err)
+	}
+	var speed float64
+	if err := tx.QueryRow(ctx, "SELECT odometry_vehicle_speed FROM telemetry WHERE vehicle_id = 'T_conflict'").Scan(&speed); err != nil || speed != 2 {
+		t.Errorf("speed = %g, %v, want the last duplicate", speed, err)
+	}
+
+	// Only key columns: nothing to overwrite, the row is skipped
+	res, err = copyTelemetry(ctx, tx, cols[:2], pgx.CopyFromRows([][]interface{}{{"T_conflict", ts}}), conflictOverwrite)
+	if err != nil || res.Skipped != 1 || res.written["T_conflict"] != 0 {
+		t.Errorf("key-only overwrite: %+v, %v", res, err)
+	}
+
+	// Written rows are counted per vehicle, updates included
+	res, err = copyTelemetry(ctx, tx, cols, pgx.CopyFromRows([][]interface{}{
+		{"T_conflict", ts, 3.0},
+		{"T_conflict2", ts, 3.0},
+		{"T_conflict2", ts.Add(time.Second), 3.0},
+	}), conflictOverwrite)
+	if err != nil || res.Inserted != 2 || res.Updated != 1 || res.written["T_conflict"] != 1 || res.written["T_conflict2"] != 2 {
+		t.Errorf("two vehicles: %+v, %v", res, err)
+	}
+}
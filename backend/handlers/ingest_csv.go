@@ -2,15 +2,18 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
-	"reflect"
+	"os"
 	"strconv"
 	"strings"
-	"telemetry-dashboard/my_structs"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -29,181 +32,326 @@ func IngestCSV(c *gin.Context, pool *pgxpool.Pool) {
 		return
 	}
 
-	// Limit file size 15 MB
-	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 15<<20)
+	// Limit upload size (configurable, see INGEST_MAX_UPLOAD_BYTES)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes)
 
-	file, header, err := c.Request.FormFile("file")
+	mr, err := c.Request.MultipartReader()
 	if err != nil {
 		slog.Error("file upload failed", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file upload failed: " + err.Error()})
 		return
 	}
-	defer file.Close()
-
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".csv") {
-		slog.Warn("invalid file extension", "filename", header.Filename)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file extension, must be .csv"})
+	uploads, fields, err := readIngestForm(mr)
+	removeSpooled := func() {
+		for _, u := range uploads {
+			os.Remove(u.path)
+		}
+	}
+	if err != nil {
+		removeSpooled()
+		status := http.StatusBadRequest
+		if errors.Is(err, errSpool) {
+			status = http.StatusInternalServerError
+		}
+		slog.Error("file upload failed", "error", err)
+		c.JSON(status, gin.H{"error": "file upload failed: " + err.Error()})
+		return
+	}
+	if len(uploads) == 0 {
+		slog.Warn("no files in upload")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file upload failed: no file parts named 'file'"})
 		return
 	}
 
-	// Extract vehicle_id from file name
-	// Example: B183_2019-06-24_03-16-13_2019-06-24_18-54-06.csv
-	parts := strings.Split(header.Filename, "_")
-	vehicleID := parts[0]
-	slog.Info("starting CSV ingest",
-		"filename", header.Filename,
-		"vehicle_id", vehicleID,
-	)
+	// fail answers with a client error and drops the spooled files
+	fail := func(msg string) {
+		removeSpooled()
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+	}
+	conflict, err := parseConflictMode(ingestParam(c, fields, "conflict"))
+	if err != nil {
+		slog.Warn("invalid conflict mode", "error", err)
+		fail(err.Error())
+		return
+	}
+	lenient, err := parseIngestMode(ingestParam(c, fields, "mode"))
+	if err != nil {
+		slog.Warn("invalid ingest mode", "error", err)
+		fail(err.Error())
+		return
+	}
+	atomic, err := parseBoolParam(ingestParam(c, fields, "atomic"))
+	if err != nil {
+		slog.Warn("invalid atomic param", "error", err)
+		fail("invalid atomic value, must be true or false")
+		return
+	}
+	profileName := ingestParam(c, fields, "profile")
+	profile, ok := mappingProfiles.get(profileName)
+	if !ok {
+		slog.Warn("unknown mapping profile", "profile", profileName)
+		fail("unknown mapping profile: " + profileName)
+		return
+	}
+	opts := ingestOptions{
+		Conflict:  conflict,
+		Lenient:   lenient,
+		Atomic:    atomic,
+		Profile:   profile,
+		Rules:     validationRules.get(),
+		VehicleID: ingestParam(c, fields, "vehicle_id"),
+	}
 
-	reader := csv.NewReader(file)
-	reader.FieldsPerRecord = len(csvHeaderToDb)
+	// Check every file before queueing anything. Archive entries are
+	// resolved by the worker; a single CSV is checked now so that a bad
+	// vehicle is reported right away.
+	type acceptedFile struct {
+		Filename      string `json:"filename"`
+		VehicleID     string `json:"vehicle_id,omitempty"`
+		VehicleSource string `json:"vehicle_source,omitempty"`
+	}
+	accepted := make([]acceptedFile, len(uploads))
+	for i, u := range uploads {
+		accepted[i].Filename = u.filename
+		if u.format != formatZip {
+			vehicleID, vehicleSource, err := resolveVehicleID(opts.VehicleID, csvName(u.filename), profile)
+			if err != nil {
+				slog.Warn("vehicle resolution failed", "filename", u.filename, "error", err)
+				fail(u.filename + ": " + err.Error())
+				return
+			}
+			accepted[i].VehicleID, accepted[i].VehicleSource = vehicleID, vehicleSource
+		}
+	}
 
-	headerRow, err := reader.Read()
+	job, err := ingestJobs.enqueue(uploads, opts)
 	if err != nil {
-		slog.Error("failed to read CSV header row", "error", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read header row"})
+		removeSpooled()
+		slog.Warn("ingest queue full, rejecting upload", "files", len(uploads))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Map CSV headers → DB columns
-	mappedCols := make([]string, len(headerRow))
-	for i, csvCol := range headerRow {
-		dbCol, ok := csvHeaderToDb[csvCol]
-		if !ok {
-			slog.Warn("unexpected CSV column",
-				"index", i,
-				"column", csvCol,
-			)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("unexpected column %d: got '%s'", i, csvCol),
-			})
-			return
-		}
-		mappedCols[i] = dbCol
+	slog.Info("CSV ingest queued",
+		"job_id", job.ID,
+		"files", job.Filenames,
+		"atomic", opts.Atomic,
+		"conflict", opts.Conflict,
+		"lenient", opts.Lenient,
+		"profile", profile.Name,
+	)
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":     string(job.State),
+		"job_id":     job.ID,
+		"files":      accepted,
+		"status_url": "/ingest-jobs/" + job.ID,
+	})
+}
+
+// ingestOptions carries per-upload settings from the request to the worker.
+type ingestOptions struct {
+	Conflict  conflictMode
+	Lenient   bool // quarantine bad rows instead of failing the upload
+	Atomic    bool // ingest all files of a request in one transaction
+	Profile   *MappingProfile
+	Rules     *ValidationRules // nil disables validation
+	VehicleID string           // vehicle_id form value, resolved per file
+}
+
+// ingestParam reads an ingest option from the multipart form fields, falling
+// back to the query string.
+func ingestParam(c *gin.Context, fields map[string]string, key string) string {
+	if v, ok := fields[key]; ok {
+		return v
 	}
+	return c.Query(key)
+}
+
+// parseIngestMode reports whether mode selects lenient parsing.
+func parseIngestMode(mode string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "strict":
+		return false, nil
+	case "lenient":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid mode: %s (must be strict or lenient)", mode)
+	}
+}
+
+// uploadMeta describes the file an ingest reads from.
+type uploadMeta struct {
+	Filename string
+	Format   uploadFormat // formatCSV once decompressed
 
-	// Collect rows
-	var rows [][]interface{}
-	lineCount := 0
+	VehicleID     string // "" when read from a vehicle_id column
+	VehicleSource string
+	DeclaredStart *time.Time // mission range encoded in the file name, if any
+	DeclaredEnd   *time.Time
+}
+
+// maxFormFieldBytes caps a non-file form field of an upload.
+const maxFormFieldBytes = 64 << 10
+
+// errSpool marks failures to store an upload on the server side.
+var errSpool = errors.New("cannot store upload")
+
+// readIngestForm reads a multipart upload part by part. Every "file" part is
+// written once, straight to a temp file owned by the job; other parts are
+// read as form fields. On error, the uploads spooled so far are returned for
+// the caller to remove.
+func readIngestForm(mr *multipart.Reader) ([]spooledUpload, map[string]string, error) {
+	var uploads []spooledUpload
+	fields := make(map[string]string)
 	for {
-		record, err := reader.Read()
+		part, err := mr.NextPart()
 		if err == io.EOF {
-			break
+			return uploads, fields, nil
 		}
 		if err != nil {
-			slog.Error("invalid CSV row", "line", lineCount+2, "error", err) // +2: header + 1-indexed
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid csv row: " + err.Error()})
-			return
+			return uploads, nil, err
+		}
+
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes+1))
+			part.Close()
+			if err != nil {
+				return uploads, nil, err
+			}
+			if len(value) > maxFormFieldBytes {
+				return uploads, nil, fmt.Errorf("form field %s too large", part.FormName())
+			}
+			fields[part.FormName()] = string(value)
+			continue
 		}
 
-		// Convert CSV strings → Go types
-		row, convErr := parseCSVRecord(record)
-		if convErr != nil {
-			slog.Warn("parse error in row", "line", lineCount+2, "error", convErr)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "parse error: " + convErr.Error()})
-			return
+		filename := part.FileName()
+		format, err := detectUploadFormat(filename)
+		if err != nil {
+			part.Close()
+			slog.Warn("invalid file extension", "filename", filename)
+			return uploads, nil, fmt.Errorf("%s: %w", filename, err)
 		}
+		path, err := spoolPart(part)
+		part.Close()
+		if err != nil {
+			return uploads, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		uploads = append(uploads, spooledUpload{filename: filename, format: format, path: path})
+	}
+}
 
-		fullRow := append([]interface{}{vehicleID}, row...)
-		rows = append(rows, fullRow)
-		lineCount++
+// spoolPart copies an uploaded file part to a temp file and returns its path.
+func spoolPart(part io.Reader) (string, error) {
+	tmp, err := os.CreateTemp("", "ingest-*")
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errSpool, err)
 	}
+	defer tmp.Close()
 
-	cols := append([]string{"vehicle_id"}, mappedCols...)
+	if _, err := io.Copy(tmp, part); err != nil {
+		os.Remove(tmp.Name())
+		// Errors writing the temp file are ours, reading the body the client's
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return "", fmt.Errorf("%w: %v", errSpool, err)
+		}
+		return "", err
+	}
+	return tmp.Name(), nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+// parseBoolParam parses an optional boolean parameter, false when empty.
+func parseBoolParam(s string) (bool, error) {
+	if strings.TrimSpace(s) == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(strings.TrimSpace(s))
+}
 
+// ingestUpload runs ingestUploadTx in a transaction of its own.
+func ingestUpload(ctx context.Context, pool *pgxpool.Pool, r io.Reader, meta uploadMeta, opts ingestOptions, progress *ingestProgress) (ingestResult, error) {
 	tx, err := pool.Begin(ctx)
 	if err != nil {
-		slog.Error("failed to begin DB transaction", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db begin: " + err.Error()})
-		return
+		return ingestResult{}, fmt.Errorf("db begin: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
-	_, err = tx.CopyFrom(ctx, pgx.Identifier{"telemetry"}, cols, pgx.CopyFromRows(rows))
+	res, err := ingestUploadTx(ctx, tx, r, meta, opts, progress)
 	if err != nil {
-		slog.Error("bulk insert failed", "error", err, "rows", len(rows))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "copy from failed: " + err.Error()})
-		return
+		return ingestResult{}, err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		slog.Error("transaction commit failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "commit failed: " + err.Error()})
-		return
+		return ingestResult{}, fmt.Errorf("commit failed: %w", err)
 	}
 
-	slog.Info("CSV ingest completed",
-		"vehicle_id", vehicleID,
-		"rows_inserted", len(rows),
-		"filename", header.Filename,
-	)
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "inserted": len(rows), "vehicle_id": vehicleID})
+	return res, nil
 }
 
-// ParseCSVRecord dynamically maps a CSV row into []interface{} according to Telemetry struct.
-func parseCSVRecord(rec []string) ([]interface{}, error) {
-	typ := reflect.TypeOf(my_structs.Telemetry{})
-	numFields := typ.NumField()
+// ingestUploadTx ingests one file within tx, reading it according to
+// meta.Format. Columnar formats need r to be a seekable file.
+func ingestUploadTx(ctx context.Context, tx pgx.Tx, r io.Reader, meta uploadMeta, opts ingestOptions, progress *ingestProgress) (ingestResult, error) {
+	switch meta.Format {
+	case formatParquet, formatArrow, formatArrowStream:
+		f, ok := r.(seekableFile)
+		if !ok {
+			return ingestResult{}, fmt.Errorf("%s files must be uploaded directly, not inside an archive", meta.Format)
+		}
+		return ingestColumnarTx(ctx, tx, f, meta, opts, progress)
+	default:
+		return ingestCSVTx(ctx, tx, r, meta, opts, progress)
+	}
+}
 
-	if len(rec) != numFields-1 { // -1 because VehicleID comes from filename, not CSV
-		return nil, fmt.Errorf("unexpected column count: got %d, want %d", len(rec), numFields-1)
+// ingestCSVTx streams a CSV file into the telemetry table within tx, see
+// ingestRecordsTx.
+func ingestCSVTx(ctx context.Context, tx pgx.Tx, r io.Reader, meta uploadMeta, opts ingestOptions, progress *ingestProgress) (ingestResult, error) {
+	profile := opts.Profile
+	if profile == nil {
+		profile = ztbusProfile
 	}
 
-	out := make([]interface{}, numFields-1)
+	// The checksum covers the decompressed CSV content, so the same mission
+	// is recognised whether it was uploaded raw or compressed.
+	hash := sha256.New()
+	reader := csv.NewReader(io.TeeReader(r, hash))
+	reader.Comma = profile.delimiter()
+	reader.ReuseRecord = true
 
-	// Skip VehicleID (that comes from filename)
-	for i := 1; i < numFields; i++ {
-		field := typ.Field(i)
-		val := rec[i-1] // shift because VehicleID is skipped
+	headerRow, err := reader.Read()
+	if err != nil {
+		return ingestResult{}, fmt.Errorf("failed to read header row: %w", err)
+	}
+	// ReuseRecord recycles the backing array, keep our own copy
+	headerRow = append([]string(nil), headerRow...)
 
-		switch field.Type.Kind() {
-		case reflect.Struct: // time.Time
-			ts, err := time.Parse(time.RFC3339, val)
-			if err != nil {
-				return nil, fmt.Errorf("invalid time_iso: %s", val)
-			}
-			out[i-1] = ts
+	checksum := func() string { return hex.EncodeToString(hash.Sum(nil)) }
+	return ingestRecordsTx(ctx, tx, &csvRecordReader{reader: reader}, headerRow, checksum, meta, opts, progress)
+}
 
-		case reflect.Ptr:
-			elemType := field.Type.Elem().Kind()
-			if val == "NaN" || val == "-" {
-				out[i-1] = nil
-				continue
-			}
-			switch elemType {
-			case reflect.Float64:
-				f, err := strconv.ParseFloat(val, 64)
-				if err != nil {
-					return nil, fmt.Errorf("invalid float in %s: %s", field.Name, val)
-				}
-				out[i-1] = f
-			case reflect.Int, reflect.Int64:
-				// time_unix is int64, status_* are int
-				n, err := strconv.ParseInt(val, 10, 64)
-				if err != nil {
-					return nil, fmt.Errorf("invalid int in %s: %s", field.Name, val)
-				}
-				// handle int vs int64 separately
-				if field.Type.Elem().Kind() == reflect.Int {
-					out[i-1] = int(n)
-				} else {
-					out[i-1] = n
-				}
-			case reflect.String:
-				out[i-1] = val
-			default:
-				return nil, fmt.Errorf("unsupported pointer type: %s", field.Type.String())
-			}
+// csvRecordReader adapts a csv.Reader to recordReader.
+type csvRecordReader struct {
+	reader *csv.Reader
+	record []interface{}
+}
 
-		default:
-			return nil, fmt.Errorf("unsupported field kind: %s", field.Type.Kind())
+func (r *csvRecordReader) Read() ([]interface{}, int, error) {
+	rec, err := r.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, parseErr.StartLine, &recordError{Line: parseErr.StartLine, Err: parseErr.Err}
 		}
+		return nil, 0, err
 	}
+	line, _ := r.reader.FieldPos(0)
 
-	return out, nil
+	r.record = r.record[:0]
+	for _, v := range rec {
+		r.record = append(r.record, v)
+	}
+	return r.record, line, nil
 }
 
 var csvHeaderToDb = map[string]string{
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+)
+
+// ztbusHeader and ztbusRow are a ZTBus mission export header and sample.
+const (
+	ztbusHeader = "time_iso,time_unix,electric_powerDemand,gnss_altitude,gnss_course,gnss_latitude,gnss_longitude," +
+		"itcs_busRoute,itcs_numberOfPassengers,itcs_stopName,odometry_articulationAngle,odometry_steeringAngle," +
+		"odometry_vehicleSpeed,odometry_wheelSpeed_fl,odometry_wheelSpeed_fr,odometry_wheelSpeed_ml," +
+		"odometry_wheelSpeed_mr,odometry_wheelSpeed_rl,odometry_wheelSpeed_rr,status_doorIsOpen," +
+		"status_gridIsAvailable,status_haltBrakeIsActive,status_parkBrakeIsActive,temperature_ambient," +
+		"traction_brakePressure,traction_tractionForce"
+	ztbusRow = "2019-06-24T03:16:13Z,1561346173,-1000.5,441.2,175.3,47.3769,8.5417,-,12,Zürich HB,0.01,-0.02," +
+		"8.25,1,2,3,4,5,6,1,1,0,0,18.5,0,2500"
+)
+
+// csvSource returns a ZTBus source over records that follow a header line,
+// read like ingestCSV does.
+func csvSource(t *testing.T, lenient bool, progress *ingestProgress, records ...string) *recordCopySource {
+	r := csv.NewReader(strings.NewReader(ztbusHeader + "\n" + strings.Join(records, "\n") + "\n"))
+	r.FieldsPerRecord = -1
+	_, _ = r.Read()
+	return newRecordCopySource(&csvRecordReader{reader: r}, ztbusLayout(t), "B183.csv", "B183", lenient, nil, progress)
+}
+
+func TestCSVCopySource(t *testing.T) {
+	bad := strings.Replace(ztbusRow, "8.25", "fast", 1)
+	progress := &ingestProgress{}
+	src := csvSource(t, false, progress, ztbusRow, bad)
+
+	if !src.Next() {
+		t.Fatalf("first row: %v", src.Err())
+	}
+	values, _ := src.Values()
+	if values[0] != "B183" || len(values) != 27 {
+		t.Errorf("values = %v..., want the vehicle and 26 columns", values[:2])
+	}
+
+	if src.Next() {
+		t.Fatal("invalid row was accepted")
+	}
+	// The header is line 1, so the second record is line 3
+	if err := src.Err(); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("err = %v, want a parse error at line 3", err)
+	}
+	if src.rows != 1 || progress.rows.Load() != 1 {
+		t.Errorf("rows = %d, progress = %d, want 1", src.rows, progress.rows.Load())
+	}
+}
+
+func TestCSVCopySourceLenient(t *testing.T) {
+	progress := &ingestProgress{}
+	src := csvSource(t, true, progress,
+		ztbusRow,
+		strings.Replace(ztbusRow, "8.25", "fast", 1),
+		"2019-06-24T03:16:14Z,1",
+		ztbusRow,
+	)
+
+	n := 0
+	for src.Next() {
+		n++
+	}
+	if src.Err() != nil || n != 2 {
+		t.Fatalf("read %d rows, err %v, want 2 rows", n, src.Err())
+	}
+
+	rejected, total := progress.rejected.snapshot(-1)
+	if total != 2 || len(rejected) != 2 {
+		t.Fatalf("rejected %d rows (%d listed), want 2", total, len(rejected))
+	}
+	if e := rejected[0]; e.File != "B183.csv" || e.Line != 3 || e.Column != "odometry_vehicleSpeed" {
+		t.Errorf("first rejection = %+v, want line 3 in odometry_vehicleSpeed", e)
+	}
+	if e := rejected[1]; e.Line != 4 || e.Column != "" {
+		t.Errorf("second rejection = %+v, want line 4 without a column", e)
+	}
+}
+
+func TestParseIngestMode(t *testing.T) {
+	for mode, want := range map[string]bool{"": false, "strict": false, "Lenient": true} {
+		if got, err := parseIngestMode(mode); err != nil || got != want {
+			t.Errorf("parseIngestMode(%q) = %v, %v, want %v", mode, got, err, want)
+		}
+	}
+	if _, err := parseIngestMode("relaxed"); err == nil {
+		t.Error("parseIngestMode accepted relaxed")
+	}
+}
+
+func TestParseBoolParam(t *testing.T) {
+	for in, want := range map[string]bool{"": false, " ": false, "true": true, "1": true, "false": false, " TRUE ": true} {
+		if got, err := parseBoolParam(in); err != nil || got != want {
+			t.Errorf("parseBoolParam(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseBoolParam("yes"); err == nil {
+		t.Error(`parseBoolParam("yes") should fail`)
+	}
+}
+
+// multipartBody writes a multipart form with fields and "file" parts, in
+// order, and returns a reader over it.
+func multipartBody(t *testing.T, fields map[string]string, files ...[2]string) *multipart.Reader {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	for _, f := range files {
+		part, err := w.CreateFormFile("file", f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(f[1]))
+	}
+	w.Close()
+	return multipart.NewReader(&buf, w.Boundary())
+}
+
+func TestReadIngestForm(t *testing.T) {
+	mr := multipartBody(t, map[string]string{"mode": "lenient", "vehicle_id": "B183"},
+		[2]string{"B183.csv", ztbusHeader + "\n" + ztbusRow + "\n"},
+		[2]string{"B208.zip", "PK"},
+	)
+	uploads, fields, err := readIngestForm(mr)
+	for _, u := range uploads {
+		defer os.Remove(u.path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["mode"] != "lenient" || fields["vehicle_id"] != "B183" {
+		t.Errorf("fields = %v", fields)
+	}
+	if len(uploads) != 2 || uploads[0].format != formatCSV || uploads[1].format != formatZip {
+		t.Fatalf("uploads = %+v", uploads)
+	}
+	if data, _ := os.ReadFile(uploads[0].path); !strings.HasPrefix(string(data), "time_iso,") {
+		t.Errorf("spooled %s holds %.20q", uploads[0].filename, data)
+	}
+
+	// The files before the bad one are handed back for removal
+	mr = multipartBody(t, nil, [2]string{"B183.csv", "x"}, [2]string{"B208.xlsx", "x"})
+	uploads, _, err = readIngestForm(mr)
+	for _, u := range uploads {
+		os.Remove(u.path)
+	}
+	if err == nil || !strings.Contains(err.Error(), "B208.xlsx") || len(uploads) != 1 {
+		t.Errorf("bad extension: %d uploads, err %v", len(uploads), err)
+	}
+}
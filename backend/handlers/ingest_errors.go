@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// At most this many rejected rows are kept per job; the total is still counted.
+const maxRowErrors = 10000
+
+// Number of rejected rows embedded in a job status response.
+const inlineRowErrors = 100
+
+// RowError describes a row that was quarantined during a lenient ingest.
+type RowError struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Column string `json:"column,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type rowErrorLog struct {
+	mu     sync.Mutex
+	errors []RowError
+	total  int64
+}
+
+func (l *rowErrorLog) add(e RowError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total++
+	if len(l.errors) < maxRowErrors {
+		l.errors = append(l.errors, e)
+	}
+}
+
+// snapshot returns up to limit recorded errors (all when limit < 0) and the
+// total number of rejected rows.
+func (l *rowErrorLog) snapshot(limit int) ([]RowError, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(l.errors)
+	if limit >= 0 && limit < n {
+		n = limit
+	}
+	return append([]RowError(nil), l.errors[:n]...), l.total
+}
+
+// ingestProgress is shared between a running ingest and status readers.
+type ingestProgress struct {
+	rows     atomic.Int64
+	rejected rowErrorLog
+}
+
+// GetIngestJobErrors downloads the rejected-row report of a job as CSV.
+func GetIngestJobErrors(c *gin.Context) {
+	id := c.Param("id")
+	rowErrors, ok := ingestJobs.rowErrors(id)
+	if !ok {
+		slog.Warn("ingest job not found", "job_id", id)
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=ingest-"+id+"-errors.csv")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"file", "line", "column", "reason"})
+	for _, e := range rowErrors {
+		_ = w.Write([]string{e.File, strconv.Itoa(e.Line), e.Column, e.Reason})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		slog.Error("failed to write error report", "job_id", id, "error", err)
+	}
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import "testing"
+
+func TestRowErrorLog(t *testing.T) {
+	var l rowErrorLog
+	for i := 0; i < maxRowErrors+5; i++ {
+		l.add(RowError{Line: i + 2, Reason: "bad"})
+	}
+
+	all, total := l.snapshot(-1)
+	if total != maxRowErrors+5 || len(all) != maxRowErrors {
+		t.Errorf("snapshot(-1) = %d errors, total %d, want %d and %d", len(all), total, maxRowErrors, maxRowErrors+5)
+	}
+	first, _ := l.snapshot(inlineRowErrors)
+	if len(first) != inlineRowErrors || first[0].Line != 2 {
+		t.Errorf("snapshot(%d) = %d errors starting at line %d", inlineRowErrors, len(first), first[0].Line)
+	}
+
+	// Snapshots are copies
+	first[0].Reason = "changed"
+	if again, _ := l.snapshot(1); again[0].Reason != "bad" {
+		t.Error("snapshot shares its backing array with the log")
+	}
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobPartial   JobState = "partial" // some files of a non-atomic batch failed
+	JobFailed    JobState = "failed"
+)
+
+// Finished jobs are kept this long so clients can still poll their result.
+const jobRetention = 24 * time.Hour
+
+var errQueueFull = errors.New("ingest queue is full, try again later")
+
+// IngestJob is the client-facing snapshot of an ingest job.
+type IngestJob struct {
+	ID            string             `json:"id"`
+	State         JobState           `json:"state"`
+	Filenames     []string           `json:"filenames"`
+	Atomic        bool               `json:"atomic"`
+	Profile       string             `json:"profile"`
+	Conflict      string             `json:"conflict"`
+	RowsProcessed int64              `json:"rows_processed"`
+	Inserted      int64              `json:"inserted"`
+	Skipped       int64              `json:"skipped"`
+	Updated       int64              `json:"updated"`
+	Lenient       bool               `json:"lenient"`
+	MissionIDs    []int64            `json:"mission_ids,omitempty"`
+	Files         []IngestFileResult `json:"files,omitempty"`
+	Rejected      int64              `json:"rejected"`
+	RowErrors     []RowError         `json:"row_errors,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	StartedAt     *time.Time         `json:"started_at,omitempty"`
+	FinishedAt    *time.Time         `json:"finished_at,omitempty"`
+	DurationMs    int64              `json:"duration_ms"`
+}
+
+type ingestJob struct {
+	IngestJob
+	uploads  []spooledUpload // removed once the job finishes
+	opts     ingestOptions
+	progress ingestProgress // updated by the COPY source while running
+}
+
+type ingestJobStore struct {
+	mu    sync.RWMutex
+	jobs  map[string]*ingestJob
+	queue chan *ingestJob
+}
+
+var ingestJobs = newIngestJobStore(int(envInt64("INGEST_QUEUE_SIZE", 100)))
+
+func newIngestJobStore(queueSize int) *ingestJobStore {
+	return &ingestJobStore{
+		jobs:  make(map[string]*ingestJob),
+		queue: make(chan *ingestJob, queueSize),
+	}
+}
+
+// StartIngestWorkers launches the worker pool that processes queued ingest
+// jobs. The number of workers is read from INGEST_WORKERS (default 4).
+func StartIngestWorkers(pool *pgxpool.Pool) {
+	workers := int(envInt64("INGEST_WORKERS", 4))
+	for i := 0; i < workers; i++ {
+		go ingestJobs.work(pool, i)
+	}
+	slog.Info("ingest workers started", "workers", workers, "queue_size", cap(ingestJobs.queue))
+}
+
+func (s *ingestJobStore) enqueue(uploads []spooledUpload, opts ingestOptions) (IngestJob, error) {
+	filenames := make([]string, len(uploads))
+	for i, u := range uploads {
+		filenames[i] = u.filename
+	}
+
+	job := &ingestJob{
+		IngestJob: IngestJob{
+			ID:        newJobID(),
+			State:     JobQueued,
+			Filenames: filenames,
+			Atomic:    opts.Atomic,
+			Profile:   opts.Profile.Name,
+			Conflict:  string(opts.Conflict),
+			Lenient:   opts.Lenient,
+			CreatedAt: time.Now().UTC(),
+		},
+		uploads: uploads,
+		opts:    opts,
+	}
+
+	s.mu.Lock()
+	s.pruneLocked()
+	s.jobs[job.ID] = job
+	snapshot := job.snapshotLocked()
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- job:
+		return snapshot, nil
+	default:
+		s.mu.Lock()
+		delete(s.jobs, job.ID)
+		s.mu.Unlock()
+		return IngestJob{}, errQueueFull
+	}
+}
+
+func (s *ingestJobStore) get(id string) (IngestJob, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return IngestJob{}, false
+	}
+	return job.snapshotLocked(), true
+}
+
+func (s *ingestJobStore) list() []IngestJob {
+	s.mu.RLock()
+	out := make([]IngestJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		out = append(out, job.snapshotLocked())
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// pruneLocked drops finished jobs older than jobRetention. Caller holds s.mu.
+func (s *ingestJobStore) pruneLocked() {
+	cutoff := time.Now().Add(-jobRetention)
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *ingestJobStore) work(pool *pgxpool.Pool, worker int) {
+	for job := range s.queue {
+		s.run(pool, job, worker)
+	}
+}
+
+func (s *ingestJobStore) run(pool *pgxpool.Pool, job *ingestJob, worker int) {
+	defer func() {
+		for _, u := range job.uploads {
+			os.Remove(u.path)
+		}
+	}()
+
+	started := time.Now().UTC()
+	s.mu.Lock()
+	job.State = JobRunning
+	job.StartedAt = &started
+	s.mu.Unlock()
+
+	slog.Info("starting CSV ingest",
+		"job_id", job.ID,
+		"worker", worker,
+		"files", job.Filenames,
+		"atomic", job.opts.Atomic,
+	)
+
+	files := ingestBatch(pool, job.uploads, job.opts, &job.progress)
+
+	var total ingestResult
+	failed, rolledBack := 0, false
+	for _, f := range files {
+		if f.RolledBack {
+			rolledBack = true
+			continue
+		}
+		if f.Error != "" {
+			failed++
+			continue
+		}
+		total.Inserted += f.Inserted
+		total.Skipped += f.Skipped
+		total.Updated += f.Updated
+		total.MissionIDs = append(total.MissionIDs, f.MissionIDs...)
+	}
+
+	var err error
+	switch {
+	case failed == 0 && !rolledBack:
+	case len(files) == 1:
+		err = errors.New(files[0].Error)
+	case failed == 0:
+		err = fmt.Errorf("batch rolled back: %s", files[0].Error)
+	case rolledBack:
+		err = fmt.Errorf("%d of %d files failed, batch rolled back", failed, len(files))
+	default:
+		err = fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+
+	finished := time.Now().UTC()
+	s.mu.Lock()
+	job.FinishedAt = &finished
+	job.Inserted = total.Inserted
+	job.Skipped = total.Skipped
+	job.Updated = total.Updated
+	job.MissionIDs = total.MissionIDs
+	job.Files = files
+	switch {
+	case err == nil:
+		job.State = JobSucceeded
+	case failed < len(files) && !rolledBack:
+		job.State = JobPartial
+		job.Error = err.Error()
+	default:
+		job.State = JobFailed
+		job.Error = err.Error()
+	}
+	state := job.State
+	s.mu.Unlock()
+
+	if err != nil {
+		slog.Error("CSV ingest failed", "job_id", job.ID, "files", job.Filenames, "error", err)
+		if state == JobFailed {
+			return
+		}
+	}
+	_, rejected := job.progress.rejected.snapshot(0)
+	slog.Info("CSV ingest completed",
+		"job_id", job.ID,
+		"files", len(files),
+		"rows_inserted", total.Inserted,
+		"rows_skipped", total.Skipped,
+		"rows_updated", total.Updated,
+		"rows_rejected", rejected,
+		"duration", finished.Sub(started),
+	)
+}
+
+// snapshotLocked copies the job state for clients. Caller holds the store lock.
+func (j *ingestJob) snapshotLocked() IngestJob {
+	out := j.IngestJob
+	out.RowsProcessed = j.progress.rows.Load()
+	out.RowErrors, out.Rejected = j.progress.rejected.snapshot(inlineRowErrors)
+	if j.StartedAt != nil {
+		end := time.Now().UTC()
+		if j.FinishedAt != nil {
+			end = *j.FinishedAt
+		}
+		out.DurationMs = end.Sub(*j.StartedAt).Milliseconds()
+	}
+	return out
+}
+
+// rowErrors returns every recorded rejected row of a job.
+func (s *ingestJobStore) rowErrors(id string) ([]RowError, bool) {
+	s.mu.RLock()
+	job, ok := s.jobs[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	rowErrors, _ := job.progress.rejected.snapshot(-1)
+	return rowErrors, true
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func GetIngestJob(c *gin.Context) {
+	id := c.Param("id")
+	job, ok := ingestJobs.get(id)
+	if !ok {
+		slog.Warn("ingest job not found", "job_id", id)
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func ListIngestJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, ingestJobs.list())
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIngestJobStoreEnqueue(t *testing.T) {
+	s := newIngestJobStore(1)
+
+	job, err := s.enqueue([]spooledUpload{
+		{filename: "B183.csv.gz", format: formatGzip, path: "/tmp/upload"},
+		{filename: "B208.zip", format: formatZip, path: "/tmp/upload2"},
+	}, ingestOptions{Conflict: conflictSkip, Profile: ztbusProfile, Atomic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobQueued || job.ID == "" || job.Profile != defaultProfileName || !job.Atomic || job.Conflict != "skip" {
+		t.Errorf("enqueued job = %+v", job)
+	}
+	if got, ok := s.get(job.ID); !ok || len(got.Filenames) != 2 || got.Filenames[1] != "B208.zip" {
+		t.Errorf("get(%s) = %+v, %v", job.ID, got, ok)
+	}
+
+	// The queue holds one job; a rejected job must not stay listed
+	if _, err := s.enqueue([]spooledUpload{{filename: "B208.csv", format: formatCSV}}, ingestOptions{Profile: ztbusProfile}); !errors.Is(err, errQueueFull) {
+		t.Fatalf("err = %v, want errQueueFull", err)
+	}
+	if jobs := s.list(); len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("list = %+v, want only %s", jobs, job.ID)
+	}
+}
+
+func TestIngestJobStorePrune(t *testing.T) {
+	s := newIngestJobStore(4)
+	now := time.Now().UTC()
+	old, recent := now.Add(-jobRetention-time.Minute), now.Add(-time.Minute)
+	s.jobs["old"] = &ingestJob{IngestJob: IngestJob{ID: "old", CreatedAt: old, FinishedAt: &old}}
+	s.jobs["recent"] = &ingestJob{IngestJob: IngestJob{ID: "recent", CreatedAt: recent, FinishedAt: &recent}}
+	s.jobs["running"] = &ingestJob{IngestJob: IngestJob{ID: "running", CreatedAt: old, StartedAt: &old}}
+
+	s.mu.Lock()
+	s.pruneLocked()
+	s.mu.Unlock()
+
+	jobs := s.list()
+	if len(jobs) != 2 || jobs[0].ID != "recent" || jobs[1].ID != "running" {
+		t.Fatalf("list = %+v, want recent then running", jobs)
+	}
+	if jobs[1].DurationMs < jobRetention.Milliseconds() {
+		t.Errorf("running job duration = %dms, want it to grow until finished", jobs[1].DurationMs)
+	}
+}
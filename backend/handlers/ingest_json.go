@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"telemetry-dashboard/my_structs"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Per-record acknowledgement states.
+const (
+	recordAccepted = "accepted"
+	recordRejected = "rejected"
+)
+
+// RecordAck acknowledges one record of a POST /ingest request.
+type RecordAck struct {
+	Index    int      `json:"index"` // position in the request, 0-based
+	Status   string   `json:"status"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"` // findings of flag and clamp rules
+}
+
+// RecordIngestResponse is the result of a POST /ingest request.
+type RecordIngestResponse struct {
+	Received int         `json:"received"`
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Inserted int64       `json:"inserted"`
+	Skipped  int64       `json:"skipped"`
+	Updated  int64       `json:"updated"`
+	Records  []RecordAck `json:"records"`
+}
+
+// telemetryLayout lays out every my_structs.Telemetry column except
+// vehicle_id, in struct order, for records that arrive already typed.
+var telemetryLayout, telemetryFieldIndex = func() (*columnLayout, []int) {
+	typ := reflect.TypeOf(my_structs.Telemetry{})
+	layout := &columnLayout{profile: ztbusProfile, vehicleIndex: -1}
+	var index []int
+	for i := 0; i < typ.NumField(); i++ {
+		col := typ.Field(i).Tag.Get("db")
+		if col == "" || col == "vehicle_id" {
+			continue
+		}
+		if col == "time_iso" {
+			layout.timeField = len(layout.fields)
+		}
+		layout.header = append(layout.header, col)
+		layout.fields = append(layout.fields, columnField{index: len(layout.fields), col: col, typ: typ.Field(i).Type})
+		index = append(index, i)
+	}
+	return layout, index
+}()
+
+// IngestRecords writes telemetry records pushed by vehicle gateways. The body
+// is a JSON array of my_structs.Telemetry records or NDJSON (one record per
+// line). Every record is validated on its own and acknowledged in the
+// response; valid records are written in one batch. The optional conflict
+// parameter defaults to skip so that gateways can safely retry.
+func IngestRecords(c *gin.Context, pool *pgxpool.Pool) {
+	mode := conflictSkip
+	if raw := c.Query("conflict"); raw != "" {
+		var err error
+		if mode, err = parseConflictMode(raw); err != nil {
+			slog.Warn("invalid conflict mode", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRecordBodyBytes)
+	raws, err := splitRecords(c.Request.Body, c.ContentType(), int(maxIngestRecords))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, errTooManyRecords):
+		slog.Warn("too many records in request", "max", maxIngestRecords)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("too many records (max %d)", maxIngestRecords)})
+		return
+	case errors.As(err, &tooLarge):
+		slog.Warn("record body too large", "max_bytes", maxRecordBodyBytes)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("body too large (max %d bytes)", maxRecordBodyBytes)})
+		return
+	case err != nil:
+		slog.Warn("invalid record body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+		return
+	}
+	if len(raws) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no records in body"})
+		return
+	}
+
+	resp := RecordIngestResponse{Received: len(raws), Records: make([]RecordAck, len(raws))}
+	checker := newRuleChecker(validationRules.get(), telemetryLayout)
+	vehicles := make(missionTracker)
+	var rows [][]interface{}
+
+	for i, raw := range raws {
+		ack := &resp.Records[i]
+		ack.Index = i
+
+		vehicle, row, err := decodeRecord(raw, "")
+		if err == nil {
+			st := vehicles.get(vehicle)
+			seen := len(st.findings)
+			if f := checker.check(i, row, st); f != nil {
+				err = fmt.Errorf("%s: %s", f.Rule, f.Detail)
+			} else {
+				for _, f := range st.findings[seen:] {
+					ack.Warnings = append(ack.Warnings, fmt.Sprintf("%s %s: %s (%s)", f.Rule, f.Column, f.Detail, f.Action))
+				}
+			}
+		}
+		if err != nil {
+			ack.Status, ack.Error = recordRejected, err.Error()
+			resp.Rejected++
+			continue
+		}
+
+		ack.Status = recordAccepted
+		resp.Accepted++
+		rows = append(rows, append([]interface{}{vehicle}, row...))
+	}
+
+	if len(rows) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		res, err := writeRecords(ctx, pool, rows, mode)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+				status = http.StatusConflict
+			}
+			slog.Error("record ingest failed", "records", len(rows), "error", err)
+			c.JSON(status, gin.H{"error": "write failed: " + err.Error()})
+			return
+		}
+		resp.Inserted, resp.Skipped, resp.Updated = res.Inserted, res.Skipped, res.Updated
+	}
+
+	slog.Info("records ingested",
+		"received", resp.Received,
+		"accepted", resp.Accepted,
+		"rejected", resp.Rejected,
+		"inserted", resp.Inserted,
+	)
+
+	status := http.StatusOK
+	switch {
+	case resp.Accepted == 0:
+		status = http.StatusUnprocessableEntity
+	case resp.Rejected > 0:
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, resp)
+}
+
+// errTooManyRecords is returned by splitRecords past its record limit.
+var errTooManyRecords = errors.New("too many records")
+
+// splitRecords splits a JSON array or an NDJSON body into raw records. A
+// body starting with '[' is read as an array, anything else as NDJSON, where
+// blank lines are ignored. Reading stops with errTooManyRecords as soon as
+// record limit+1 is found.
+func splitRecords(body io.Reader, contentType string, limit int) ([]json.RawMessage, error) {
+	br := bufio.NewReader(body)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []json.RawMessage
+	if first == '[' && contentType != "application/x-ndjson" {
+		dec := json.NewDecoder(br)
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			if len(out) == limit {
+				return nil, errTooManyRecords
+			}
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, err
+			}
+			out = append(out, raw)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(out) == limit {
+			return nil, errTooManyRecords
+		}
+		out = append(out, json.RawMessage(append([]byte(nil), line...)))
+	}
+	return out, scanner.Err()
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b)) {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+// decodeRecord parses and checks a single record and returns its vehicle and
+// its values in telemetryLayout order. A non-empty vehicle is used for
+// records without vehicle_id; records naming another vehicle are refused, as
+// are records with unknown fields, which would otherwise be stored as NULLs.
+func decodeRecord(raw json.RawMessage, vehicle string) (string, []interface{}, error) {
+	var t my_structs.Telemetry
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return "", nil, fmt.Errorf("invalid record: %w", err)
+	}
+	if t.VehicleID != nil && strings.TrimSpace(*t.VehicleID) != "" {
+		id := strings.TrimSpace(*t.VehicleID)
+		if vehicle != "" && id != vehicle {
+			return "", nil, fmt.Errorf("vehicle_id %q does not match %q", id, vehicle)
+		}
+		vehicle = id
+	}
+	if vehicle == "" {
+		return "", nil, fmt.Errorf("missing vehicle_id")
+	}
+	if err := validateVehicleID(vehicle); err != nil {
+		return "", nil, err
+	}
+	if t.TimeISO.IsZero() {
+		return "", nil, fmt.Errorf("missing time_iso")
+	}
+
+	v := reflect.ValueOf(t)
+	row := make([]interface{}, len(telemetryFieldIndex))
+	for i, idx := range telemetryFieldIndex {
+		f := v.Field(idx)
+		switch {
+		case f.Kind() != reflect.Ptr:
+			row[i] = f.Interface()
+		case f.IsNil():
+			row[i] = nil
+		default:
+			row[i] = f.Elem().Interface()
+		}
+	}
+	return vehicle, row, nil
+}
+
+// writeRecords copies validated rows into the telemetry table in one transaction.
+func writeRecords(ctx context.Context, pool *pgxpool.Pool, rows [][]interface{}, mode conflictMode) (ingestResult, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return ingestResult{}, fmt.Errorf("db begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	cols := append([]string{"vehicle_id"}, telemetryLayout.columns()...)
+	res, err := copyTelemetry(ctx, tx, cols, pgx.CopyFromRows(rows), mode)
+	if err != nil {
+		return ingestResult{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return ingestResult{}, fmt.Errorf("commit failed: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitRecords(t *testing.T) {
+	rec := `{"vehicle_id":"B183","time_iso":"2019-06-24T03:16:13Z"}`
+	bodies := []struct {
+		body, contentType string
+		want              int
+	}{
+		{"[" + rec + "," + rec + "]", "application/json", 2},
+		{"  \n [" + rec + "]", "", 1},
+		{rec + "\n\n" + rec + "\r\n" + rec, "application/x-ndjson", 3},
+		{"", "application/json", 0},
+		{" \n ", "application/x-ndjson", 0},
+	}
+	for _, b := range bodies {
+		got, err := splitRecords(strings.NewReader(b.body), b.contentType, 3)
+		if err != nil || len(got) != b.want {
+			t.Errorf("%q: %d records, %v, want %d", b.body, len(got), err, b.want)
+			continue
+		}
+		for _, raw := range got {
+			if string(raw) != rec {
+				t.Errorf("%q: record %s", b.body, raw)
+			}
+		}
+	}
+
+	if _, err := splitRecords(strings.NewReader("["+rec), "application/json", 3); err == nil {
+		t.Error("truncated array accepted")
+	}
+
+	// Past the limit reading stops, before a broken tail would be found
+	for _, body := range []string{"[" + rec + "," + rec + "," + rec + ",{", rec + "\n" + rec + "\n" + rec + "\n{"} {
+		if _, err := splitRecords(strings.NewReader(body), "", 2); !errors.Is(err, errTooManyRecords) {
+			t.Errorf("%q: err = %v, want errTooManyRecords", body, err)
+		}
+	}
+}
+
+func TestDecodeRecord(t *testing.T) {
+	vehicle, row, err := decodeRecord(json.RawMessage(
+		`{"vehicle_id":" B183 ","time_iso":"2019-06-24T03:16:13Z","odometry_vehicle_speed":8.25,"status_door_is_open":1}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vehicle != "B183" || len(row) != len(telemetryLayout.fields) {
+		t.Fatalf("vehicle %q, %d values", vehicle, len(row))
+	}
+
+	byCol := make(map[string]interface{})
+	for i, f := range telemetryLayout.fields {
+		byCol[f.col] = row[i]
+	}
+	if byCol["time_iso"] != time.Date(2019, 6, 24, 3, 16, 13, 0, time.UTC) {
+		t.Errorf("time_iso = %v", byCol["time_iso"])
+	}
+	if byCol["odometry_vehicle_speed"] != 8.25 || byCol["status_door_is_open"] != 1 || byCol["gnss_latitude"] != nil {
+		t.Errorf("values = %v", byCol)
+	}
+	if row[telemetryLayout.timeField] != byCol["time_iso"] {
+		t.Error("timeField does not point at time_iso")
+	}
+
+	for raw, want := range map[string]string{
+		`{"time_iso":"2019-06-24T03:16:13Z"}`:                      "missing vehicle_id",
+		`{"vehicle_id":"  ","time_iso":"2019-06-24T03:16:13Z"}`:    "missing vehicle_id",
+		`{"vehicle_id":"B/183","time_iso":"2019-06-24T03:16:13Z"}`: "vehicle",
+		`{"vehicle_id":"B183"}`:                                    "missing time_iso",
+		`{"vehicle_id":"B183","time_iso":"yesterday"}`:             "invalid record",
+		`["B183"]`: "invalid record",
+		`{"vehicle_id":"B183","time_iso":"2019-06-24T03:16:13Z","speed":8.25}`: "unknown field",
+	} {
+		if _, _, err := decodeRecord(json.RawMessage(raw), ""); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", raw, err, want)
+		}
+	}
+
+	// A vehicle from the transport fills in and must match the record's
+	ts := `"time_iso":"2019-06-24T03:16:13Z"`
+	if vehicle, _, err := decodeRecord(json.RawMessage(`{`+ts+`}`), "B208"); err != nil || vehicle != "B208" {
+		t.Errorf("record without vehicle_id: %q, %v", vehicle, err)
+	}
+	if vehicle, _, err := decodeRecord(json.RawMessage(`{"vehicle_id":"B208",`+ts+`}`), "B208"); err != nil || vehicle != "B208" {
+		t.Errorf("matching vehicle_id: %q, %v", vehicle, err)
+	}
+	if _, _, err := decodeRecord(json.RawMessage(`{"vehicle_id":"B183",`+ts+`}`), "B208"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("mismatching vehicle_id: err = %v", err)
+	}
+}
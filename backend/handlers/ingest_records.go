@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// recordReader yields the rows of an uploaded file, whatever its format.
+// Cells are strings for text formats and typed Go values (float64, int64,
+// bool, string, time.Time or nil) for columnar ones.
+type recordReader interface {
+	// Read returns the next record and its position in the file: the line
+	// for text formats, the 1-based row number otherwise. It returns io.EOF
+	// at the end and a *recordError for a row that can be skipped.
+	Read() (record []interface{}, line int, err error)
+}
+
+// recordError is a malformed row that does not prevent reading the next one.
+type recordError struct {
+	Line int
+	Err  error
+}
+
+func (e *recordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *recordError) Unwrap() error {
+	return e.Err
+}
+
+// ingestRecordsTx streams the records of a file into the telemetry table
+// within tx, resolving key conflicts according to opts, and records one
+// mission per vehicle found in the file. header names the record columns.
+// checksum is called once all records were read. meta.VehicleID is used for
+// rows without a vehicle_id column value. progress, if not nil, tracks parsed
+// and rejected rows while the ingest runs.
+func ingestRecordsTx(ctx context.Context, tx pgx.Tx, rr recordReader, header []string, checksum func() string,
+	meta uploadMeta, opts ingestOptions, progress *ingestProgress) (ingestResult, error) {
+	profile := opts.Profile
+	if profile == nil {
+		profile = ztbusProfile
+	}
+
+	// Map file columns → DB columns
+	layout, err := newColumnLayout(profile, header)
+	if err != nil {
+		return ingestResult{}, err
+	}
+
+	if meta.VehicleID == "" && layout.vehicleIndex < 0 {
+		return ingestResult{}, fmt.Errorf("file has no vehicle_id column and no vehicle was given")
+	}
+
+	cols := append([]string{"vehicle_id"}, layout.columns()...)
+
+	// Rows are parsed lazily while COPY pulls them, so memory stays flat
+	// regardless of file size.
+	src := newRecordCopySource(rr, layout, meta.Filename, meta.VehicleID, opts.Lenient, opts.Rules, progress)
+	res, err := copyTelemetry(ctx, tx, cols, src, opts.Conflict)
+	if err != nil {
+		if srcErr := src.Err(); srcErr != nil {
+			return ingestResult{}, srcErr
+		}
+		return ingestResult{}, fmt.Errorf("copy from failed: %w", err)
+	}
+
+	res.MissionIDs, err = recordMissions(ctx, tx, meta, checksum(), src.missions, res)
+	if err != nil {
+		return ingestResult{}, err
+	}
+
+	return res, nil
+}
+
+// recordCopySource streams file records into COPY one row at a time.
+// It implements pgx.CopyFromSource. In lenient mode bad rows are recorded
+// in progress.rejected and skipped instead of aborting the COPY.
+type recordCopySource struct {
+	reader    recordReader
+	layout    *columnLayout
+	file      string
+	vehicleID string
+	lenient   bool
+	row       []interface{}
+	rows      int
+	missions  missionTracker
+	checker   *ruleChecker
+	progress  *ingestProgress
+	err       error
+}
+
+func newRecordCopySource(reader recordReader, layout *columnLayout, file, vehicleID string, lenient bool, rules *ValidationRules, progress *ingestProgress) *recordCopySource {
+	if progress == nil {
+		progress = &ingestProgress{}
+	}
+	return &recordCopySource{
+		reader:    reader,
+		layout:    layout,
+		file:      file,
+		vehicleID: vehicleID,
+		lenient:   lenient,
+		missions:  make(missionTracker),
+		checker:   newRuleChecker(rules, layout),
+		progress:  progress,
+	}
+}
+
+func (s *recordCopySource) Next() bool {
+	for {
+		record, line, err := s.reader.Read()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			var recErr *recordError
+			if errors.As(err, &recErr) && s.reject(RowError{Line: recErr.Line, Reason: recErr.Err.Error()}) {
+				continue
+			}
+			s.err = fmt.Errorf("invalid row: %w", err)
+			return false
+		}
+
+		// Convert cells → Go types
+		row, convErr := s.layout.parseRecord(record)
+		if convErr != nil {
+			rowErr := RowError{Line: line, Reason: convErr.Error()}
+			var colErr *columnError
+			if errors.As(convErr, &colErr) && colErr.Index < len(s.layout.header) {
+				rowErr.Column = s.layout.header[colErr.Index]
+			}
+			if s.reject(rowErr) {
+				continue
+			}
+			s.err = fmt.Errorf("parse error at line %d: %w", line, convErr)
+			return false
+		}
+
+		vehicle, vehErr := s.vehicleFor(record)
+		if vehErr != nil {
+			rowErr := RowError{Line: line, Column: s.layout.header[s.layout.vehicleIndex], Reason: vehErr.Error()}
+			if s.reject(rowErr) {
+				continue
+			}
+			s.err = fmt.Errorf("parse error at line %d: %w", line, vehErr)
+			return false
+		}
+
+		// Rows rejected by a validation rule are dropped in either mode;
+		// they are kept as findings and listed with the job's rejected rows.
+		if f := s.checker.check(line, row, s.missions.get(vehicle)); f != nil {
+			s.progress.rejected.add(RowError{File: s.file, Line: line, Column: f.Column, Reason: f.Rule + ": " + f.Detail})
+			continue
+		}
+
+		s.row = append([]interface{}{vehicle}, row...)
+		s.missions.observe(vehicle, row[s.layout.timeField].(time.Time))
+		s.rows++
+		s.progress.rows.Add(1)
+		return true
+	}
+}
+
+// vehicleFor picks the vehicle of a record. The upload's vehicle, when
+// resolved from the form or the file name, wins; a vehicle_id cell naming
+// another vehicle is an error. Without one the cell is used. Values from the
+// column are validated; the upload's vehicle already was.
+func (s *recordCopySource) vehicleFor(rec []interface{}) (string, error) {
+	if s.layout.vehicleIndex < 0 {
+		return s.vehicleID, nil
+	}
+	var v string
+	switch cell := rec[s.layout.vehicleIndex].(type) {
+	case nil:
+	case string:
+		v = strings.TrimSpace(cell)
+	default:
+		v = fmt.Sprint(cell)
+	}
+	if v == "" || s.layout.profile.isNull(v) {
+		if s.vehicleID == "" {
+			return "", fmt.Errorf("missing vehicle_id")
+		}
+		return s.vehicleID, nil
+	}
+	if s.vehicleID != "" {
+		if v != s.vehicleID {
+			return "", fmt.Errorf("vehicle_id %q does not match the upload's vehicle %q", v, s.vehicleID)
+		}
+		return v, nil
+	}
+	if err := validateVehicleID(v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// reject records a bad row when running in lenient mode and reports whether
+// ingest may continue.
+func (s *recordCopySource) reject(e RowError) bool {
+	if !s.lenient {
+		return false
+	}
+	e.File = s.file
+	s.progress.rejected.add(e)
+	return true
+}
+
+func (s *recordCopySource) Values() ([]interface{}, error) {
+	return s.row, nil
+}
+
+func (s *recordCopySource) Err() error {
+	return s.err
+}
+
+// columnLayout binds a mapping profile to the columns of one file.
+type columnLayout struct {
+	profile      *MappingProfile
+	header       []string
+	fields       []columnField // mapped columns except vehicle_id, in COPY column order
+	vehicleIndex int           // position of the vehicle_id column, -1 if absent
+	timeField    int           // index of time_iso in fields
+}
+
+type columnField struct {
+	index int          // position in the record
+	col   string       // telemetry DB column
+	typ   reflect.Type // type of the matching my_structs.Telemetry field
+	conv  *UnitConversion
+}
+
+func newColumnLayout(profile *MappingProfile, header []string) (*columnLayout, error) {
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff") // UTF-8 BOM
+	}
+
+	layout := &columnLayout{profile: profile, header: header, vehicleIndex: -1}
+	seen := make(map[string]string) // DB column → file column
+	for i, fileCol := range header {
+		dbCol, ok := profile.Columns[fileCol]
+		if !ok {
+			if profile.IgnoreUnknown {
+				continue
+			}
+			return nil, fmt.Errorf("unexpected column %d: got '%s'", i, fileCol)
+		}
+		if prev, dup := seen[dbCol]; dup {
+			return nil, fmt.Errorf("columns '%s' and '%s' both map to %s", prev, fileCol, dbCol)
+		}
+		seen[dbCol] = fileCol
+
+		if dbCol == "vehicle_id" {
+			layout.vehicleIndex = i
+			continue
+		}
+
+		if dbCol == "time_iso" {
+			layout.timeField = len(layout.fields)
+		}
+		field := columnField{index: i, col: dbCol, typ: telemetryColumnTypes[dbCol]}
+		if conv, ok := profile.Conversions[dbCol]; ok {
+			field.conv = &conv
+		}
+		layout.fields = append(layout.fields, field)
+	}
+
+	// Every mapped, non-optional column must be present. vehicle_id may be
+	// missing when the vehicle is given with the upload.
+	var missing []string
+	for _, dbCol := range profile.Columns {
+		if dbCol == "vehicle_id" {
+			continue
+		}
+		if _, ok := seen[dbCol]; !ok && !profile.isOptional(dbCol) && !slices.Contains(missing, dbCol) {
+			missing = append(missing, dbCol)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing required columns for profile %s: %s", profile.Name, strings.Join(missing, ", "))
+	}
+
+	return layout, nil
+}
+
+func (l *columnLayout) columns() []string {
+	cols := make([]string, len(l.fields))
+	for i, f := range l.fields {
+		cols[i] = f.col
+	}
+	return cols
+}
+
+// parseRecord converts a record into COPY values using the Go types of the
+// my_structs.Telemetry fields.
+func (l *columnLayout) parseRecord(rec []interface{}) ([]interface{}, error) {
+	if len(rec) != len(l.header) {
+		return nil, fmt.Errorf("unexpected column count: got %d, want %d", len(rec), len(l.header))
+	}
+
+	out := make([]interface{}, len(l.fields))
+	for i, f := range l.fields {
+		var (
+			v   interface{}
+			err error
+		)
+		if s, ok := rec[f.index].(string); ok {
+			v, err = l.parseText(f, s)
+		} else {
+			v, err = convertTyped(f, rec[f.index])
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+
+	return out, nil
+}
+
+// parseText converts a text cell.
+func (l *columnLayout) parseText(f columnField, val string) (interface{}, error) {
+	switch f.typ.Kind() {
+	case reflect.Struct: // time.Time
+		ts, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return nil, &columnError{Index: f.index, Msg: fmt.Sprintf("invalid %s: %s", f.col, val)}
+		}
+		return ts, nil
+
+	case reflect.Ptr:
+		if l.profile.isNull(val) {
+			return nil, nil
+		}
+		switch f.typ.Elem().Kind() {
+		case reflect.Float64:
+			v, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return nil, &columnError{Index: f.index, Msg: fmt.Sprintf("invalid float in %s: %s", f.col, val)}
+			}
+			if f.conv != nil {
+				v = f.conv.apply(v)
+			}
+			return v, nil
+		case reflect.Int, reflect.Int64:
+			// time_unix is int64, status_* are int
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, &columnError{Index: f.index, Msg: fmt.Sprintf("invalid int in %s: %s", f.col, val)}
+			}
+			return intValue(f, n), nil
+		case reflect.String:
+			return val, nil
+		default:
+			return nil, fmt.Errorf("unsupported pointer type: %s", f.typ.String())
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported field kind: %s", f.typ.Kind())
+	}
+}
+
+// convertTyped converts a typed cell from a columnar file, keeping its value
+// exact where the column types allow it.
+func convertTyped(f columnField, cell interface{}) (interface{}, error) {
+	invalid := func() error {
+		return &columnError{Index: f.index, Msg: fmt.Sprintf("invalid %s: %v (%T)", f.col, cell, cell)}
+	}
+
+	if f.typ.Kind() == reflect.Struct { // time.Time
+		ts, ok := cell.(time.Time)
+		if !ok {
+			return nil, invalid()
+		}
+		return ts, nil
+	}
+	if cell == nil {
+		return nil, nil
+	}
+
+	switch f.typ.Elem().Kind() {
+	case reflect.Float64:
+		var v float64
+		switch n := cell.(type) {
+		case float64:
+			v = n
+		case int64:
+			v = float64(n)
+		case bool:
+			v = boolToFloat(n)
+		default:
+			return nil, invalid()
+		}
+		if f.conv != nil {
+			v = f.conv.apply(v)
+		}
+		return v, nil
+	case reflect.Int, reflect.Int64:
+		var n int64
+		switch v := cell.(type) {
+		case int64:
+			n = v
+		case bool:
+			n = int64(boolToFloat(v))
+		case float64:
+			if v != float64(int64(v)) {
+				return nil, invalid()
+			}
+			n = int64(v)
+		default:
+			return nil, invalid()
+		}
+		return intValue(f, n), nil
+	case reflect.String:
+		return fmt.Sprint(cell), nil
+	default:
+		return nil, fmt.Errorf("unsupported pointer type: %s", f.typ.String())
+	}
+}
+
+// intValue returns n as the int or int64 the field expects.
+func intValue(f columnField, n int64) interface{} {
+	if f.typ.Elem().Kind() == reflect.Int {
+		return int(n)
+	}
+	return n
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// columnError is a parse failure tied to a single column.
+type columnError struct {
+	Index int
+	Msg   string
+}
+
+func (e *columnError) Error() string {
+	return e.Msg
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// textRecord splits a CSV line into the string cells csvRecordReader yields.
+func textRecord(line string) []interface{} {
+	var rec []interface{}
+	for _, v := range strings.Split(line, ",") {
+		rec = append(rec, v)
+	}
+	return rec
+}
+
+func ztbusLayout(t *testing.T) *columnLayout {
+	t.Helper()
+	layout, err := newColumnLayout(ztbusProfile, strings.Split(ztbusHeader, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}
+
+func TestColumnLayoutParseRecord(t *testing.T) {
+	layout := ztbusLayout(t)
+	row, err := layout.parseRecord(textRecord(ztbusRow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(row) != 26 {
+		t.Fatalf("got %d values, want 26", len(row))
+	}
+	if got, want := row[0], time.Date(2019, 6, 24, 3, 16, 13, 0, time.UTC); got != want {
+		t.Errorf("time_iso = %v, want %v", got, want)
+	}
+	if got, ok := row[1].(int64); !ok || got != 1561346173 {
+		t.Errorf("time_unix = %#v, want int64 1561346173", row[1])
+	}
+	if got := row[2]; got != -1000.5 {
+		t.Errorf("electric_power_demand = %#v, want -1000.5", got)
+	}
+	if row[7] != nil {
+		t.Errorf(`itcs_bus_route "-" = %#v, want nil`, row[7])
+	}
+	if got := row[9]; got != "Zürich HB" {
+		t.Errorf("itcs_stop_name = %#v", got)
+	}
+	if got, ok := row[19].(int); !ok || got != 1 {
+		t.Errorf("status_door_is_open = %#v, want int 1", row[19])
+	}
+
+	bad := map[string]string{
+		"column count": "2019-06-24T03:16:13Z,1",
+		"time":         strings.Replace(ztbusRow, "2019-06-24T03:16:13Z", "24.06.2019 03:16", 1),
+		"float":        strings.Replace(ztbusRow, "8.25", "fast", 1),
+		"int":          strings.Replace(ztbusRow, "1561346173", "1.5", 1),
+	}
+	for name, rec := range bad {
+		if _, err := layout.parseRecord(textRecord(rec)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestColumnLayoutConversions(t *testing.T) {
+	profile := &MappingProfile{
+		Name:        "kmh",
+		Columns:     map[string]string{"ts": "time_iso", "speed_kmh": "odometry_vehicle_speed", "temp_f": "temperature_ambient"},
+		Conversions: map[string]UnitConversion{"odometry_vehicle_speed": {Scale: 1 / 3.6}, "temperature_ambient": {Scale: 5.0 / 9, Offset: -160.0 / 9}},
+		NullValues:  []string{"n/a"},
+	}
+	layout, err := newColumnLayout(profile, []string{"ts", "speed_kmh", "temp_f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := layout.parseRecord([]interface{}{"2019-06-24T03:16:13Z", "36", "212"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row[1] != 10.0 || row[2] != 100.0 {
+		t.Errorf("converted values = %v, %v, want 10 m/s and 100 °C", row[1], row[2])
+	}
+	// Only the profile's null values are NULL
+	if row, err := layout.parseRecord([]interface{}{"2019-06-24T03:16:13Z", "n/a", "-"}); err == nil {
+		t.Errorf("parsed %v, want %q rejected as a float", row, "-")
+	}
+}
+
+func TestNewColumnLayout(t *testing.T) {
+	profile := &MappingProfile{
+		Name:     "aliases",
+		Columns:  map[string]string{"time": "time_iso", "timestamp": "time_iso", "speed": "odometry_vehicle_speed", "temp": "temperature_ambient"},
+		Optional: []string{"temperature_ambient"},
+	}
+	lenient := *profile
+	lenient.IgnoreUnknown = true
+
+	ok := []struct {
+		profile *MappingProfile
+		header  string
+		want    string
+	}{
+		{profile, "time,speed,temp", "time_iso,odometry_vehicle_speed,temperature_ambient"},
+		{profile, "speed,timestamp", "odometry_vehicle_speed,time_iso"},
+		{profile, "\ufefftime,speed", "time_iso,odometry_vehicle_speed"},
+		{&lenient, "time,speed,extra", "time_iso,odometry_vehicle_speed"},
+	}
+	for _, tt := range ok {
+		layout, err := newColumnLayout(tt.profile, strings.Split(tt.header, ","))
+		if err != nil {
+			t.Errorf("%q: %v", tt.header, err)
+			continue
+		}
+		if got := strings.Join(layout.columns(), ","); got != tt.want {
+			t.Errorf("%q: columns = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+
+	for _, header := range []string{"time,speed,extra", "time,timestamp,speed", "time,temp"} {
+		if _, err := newColumnLayout(profile, strings.Split(header, ",")); err == nil {
+			t.Errorf("%q: no error", header)
+		}
+	}
+}
+
+func TestRecordCopySourceVehicleFor(t *testing.T) {
+	profile := &MappingProfile{Name: "fleet", Columns: map[string]string{"bus": "vehicle_id", "ts": "time_iso"}}
+	layout, err := newColumnLayout(profile, []string{"bus", "ts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		upload, cell, want string
+		wantErr            bool
+	}{
+		{"", "B183", "B183", false},
+		{"", " B208 ", "B208", false},
+		{"", "", "", true},
+		{"", "NaN", "", true},
+		{"", "B/183", "", true},
+		{"B183", "", "B183", false},
+		{"B183", " B183", "B183", false},
+		{"B183", "B208", "", true},
+	} {
+		src := newRecordCopySource(nil, layout, "fleet.csv", tt.upload, false, nil, nil)
+		got, err := src.vehicleFor([]interface{}{tt.cell, "2019-06-24T03:16:13Z"})
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("upload %q, cell %q: vehicleFor = %q, %v, want %q", tt.upload, tt.cell, got, err, tt.want)
+		}
+	}
+}
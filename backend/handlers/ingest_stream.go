@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Bounds of the retry delay after a failed stream batch write.
+const (
+	streamRetryMin = time.Second
+	streamRetryMax = time.Minute
+)
+
+// streamBatch collects the records of a batch of stream messages (MQTT,
+// Kafka) and validates them against the current rules.
+type streamBatch struct {
+	source   string // for logging, e.g. "mqtt"
+	checker  *ruleChecker
+	vehicles missionTracker
+	rows     [][]interface{}
+	rejected int
+}
+
+func newStreamBatch(source string) *streamBatch {
+	return &streamBatch{
+		source:   source,
+		checker:  newRuleChecker(validationRules.get(), telemetryLayout),
+		vehicles: make(missionTracker),
+	}
+}
+
+// add decodes a message payload holding one JSON record or an array. vehicle
+// is used for records without vehicle_id. Invalid records are logged and
+// dropped, as a redelivery would not make them valid.
+func (b *streamBatch) add(payload []byte, vehicle string, attrs ...any) {
+	raws, err := splitPayload(payload)
+	if err != nil {
+		slog.Warn("invalid "+b.source+" payload", append(attrs, "error", err)...)
+		b.rejected++
+		return
+	}
+	for _, raw := range raws {
+		vehicle, row, err := decodeRecord(raw, vehicle)
+		if err == nil {
+			if f := b.checker.check(0, row, b.vehicles.get(vehicle)); f != nil {
+				err = fmt.Errorf("%s: %s", f.Rule, f.Detail)
+			}
+		}
+		if err != nil {
+			slog.Warn("invalid "+b.source+" record", append(attrs, "error", err)...)
+			b.rejected++
+			continue
+		}
+		b.rows = append(b.rows, append([]interface{}{vehicle}, row...))
+	}
+}
+
+// write stores the batch, retrying with backoff until it commits or ctx is
+// done. Rows the database refuses as invalid are logged and dropped. It
+// returns an error only when ctx ends before the batch was written.
+func (b *streamBatch) write(ctx context.Context, pool *pgxpool.Pool) (ingestResult, error) {
+	return b.writeRows(ctx, b.rows, func(ctx context.Context, rows [][]interface{}) (ingestResult, error) {
+		return writeRecords(ctx, pool, rows, conflictSkip)
+	})
+}
+
+// writeRows writes rows with write, retrying other failures with backoff. A
+// data or constraint error would fail again on every retry, so the rows are
+// split in halves that are written on their own, until the offending rows
+// are found and dropped.
+func (b *streamBatch) writeRows(ctx context.Context, rows [][]interface{},
+	write func(context.Context, [][]interface{}) (ingestResult, error)) (ingestResult, error) {
+	if len(rows) == 0 {
+		return ingestResult{}, nil
+	}
+	delay := streamRetryMin
+	for {
+		wctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		res, err := write(wctx, rows)
+		cancel()
+		if err == nil {
+			return res, nil
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")) {
+			if len(rows) == 1 {
+				slog.Error(b.source+" record rejected by database, dropping", "vehicle", rows[0][0],
+					"time_iso", rows[0][telemetryLayout.timeField+1], "error", err)
+				b.rejected++
+				return ingestResult{}, nil
+			}
+			half := len(rows) / 2
+			first, err := b.writeRows(ctx, rows[:half], write)
+			if err != nil {
+				return first, err
+			}
+			second, err := b.writeRows(ctx, rows[half:], write)
+			first.Inserted += second.Inserted
+			first.Skipped += second.Skipped
+			first.Updated += second.Updated
+			return first, err
+		}
+		slog.Error(b.source+" batch write failed, retrying", "rows", len(rows), "retry_in", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ingestResult{}, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, streamRetryMax)
+	}
+}
+
+// splitPayload reads a message payload holding one JSON record or an array.
+func splitPayload(payload []byte) ([]json.RawMessage, error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		var out []json.RawMessage
+		err := json.Unmarshal(payload, &out)
+		return out, err
+	}
+	if !json.Valid(payload) {
+		return nil, fmt.Errorf("payload is not valid JSON")
+	}
+	return []json.RawMessage{payload}, nil
+}
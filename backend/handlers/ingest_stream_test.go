@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestSplitPayload(t *testing.T) {
+	one, err := splitPayload([]byte(" {\"time_iso\":\"2019-06-24T03:16:13Z\"}\n"))
+	if err != nil || len(one) != 1 || string(one[0]) != `{"time_iso":"2019-06-24T03:16:13Z"}` {
+		t.Errorf("single record: %q, %v", one, err)
+	}
+	many, err := splitPayload([]byte(`[{"a":1},{"a":2},{"a":3}]`))
+	if err != nil || len(many) != 3 {
+		t.Errorf("array: %d records, %v", len(many), err)
+	}
+	for _, bad := range []string{"", "not json", `{"a":`, `[{"a":1},`} {
+		if _, err := splitPayload([]byte(bad)); err == nil {
+			t.Errorf("splitPayload(%q) accepted the payload", bad)
+		}
+	}
+}
+
+func TestStreamBatchAdd(t *testing.T) {
+	b := newStreamBatch("test")
+	b.add([]byte(`[{"time_iso":"2019-06-24T03:16:13Z","odometry_vehicle_speed":8.25},{"time_iso":"2019-06-24T03:16:14Z"}]`), "B183")
+	b.add([]byte(`{"vehicle_id":"B208","time_iso":"2019-06-24T03:16:13Z"}`), "")
+	b.add([]byte(`{"time_iso":"2019-06-24T03:16:13Z"}`), "")                         // no vehicle
+	b.add([]byte(`{"vehicle_id":"B208","time_iso":"2019-06-24T03:16:13Z"}`), "B183") // topic mismatch
+	b.add([]byte(`{"vehicle_id":"B208","time_iso":"1970-01-01T00:00:00Z"}`), "")     // rejected by the time rule
+	b.add([]byte(`not json`), "B183")
+
+	var vehicles []interface{}
+	for _, row := range b.rows {
+		vehicles = append(vehicles, row[0])
+	}
+	if !reflect.DeepEqual(vehicles, []interface{}{"B183", "B183", "B208"}) || b.rejected != 4 {
+		t.Errorf("rows for %v, %d rejected, want B183, B183, B208 and 4 rejected", vehicles, b.rejected)
+	}
+	if len(b.rows[0]) != len(telemetryLayout.fields)+1 {
+		t.Errorf("row has %d values, want vehicle_id and %d columns", len(b.rows[0]), len(telemetryLayout.fields))
+	}
+}
+
+func TestStreamBatchWriteRows(t *testing.T) {
+	b := newStreamBatch("test")
+	var rows [][]interface{}
+	for _, vehicle := range []string{"B183", "B208", "BAD", "B183", "B208", "BAD", "B183"} {
+		rows = append(rows, make([]interface{}, len(telemetryLayout.fields)+1))
+		rows[len(rows)-1][0] = vehicle
+	}
+
+	// The database refuses any write holding a BAD row
+	writes := 0
+	res, err := b.writeRows(context.Background(), rows, func(_ context.Context, rows [][]interface{}) (ingestResult, error) {
+		writes++
+		for _, row := range rows {
+			if row[0] == "BAD" {
+				return ingestResult{}, &pgconn.PgError{Code: "22P02", Message: "invalid input syntax"}
+			}
+		}
+		return ingestResult{Inserted: int64(len(rows))}, nil
+	})
+	if err != nil || res.Inserted != 5 || b.rejected != 2 {
+		t.Errorf("inserted %d, rejected %d, err %v, want 5 inserted and 2 rejected", res.Inserted, b.rejected, err)
+	}
+	if writes > 2*len(rows) {
+		t.Errorf("%d writes for %d rows", writes, len(rows))
+	}
+
+	// Other failures are retried until the context ends
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = b.writeRows(ctx, rows, func(context.Context, [][]interface{}) (ingestResult, error) {
+		return ingestResult{}, errors.New("connection refused")
+	})
+	if !errors.Is(err, context.Canceled) || b.rejected != 2 {
+		t.Errorf("err = %v, rejected %d", err, b.rejected)
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// kafkaConfig is read from the KAFKA_* environment variables.
+type kafkaConfig struct {
+	brokers     []string // KAFKA_BROKERS, comma separated; empty disables the consumer
+	topics      []string // KAFKA_TOPICS, comma separated
+	group       string
+	clientID    string
+	batchSize   int
+	lagInterval time.Duration
+}
+
+func kafkaConfigFromEnv() kafkaConfig {
+	cfg := kafkaConfig{
+		brokers:     splitList(os.Getenv("KAFKA_BROKERS")),
+		topics:      splitList(os.Getenv("KAFKA_TOPICS")),
+		group:       os.Getenv("KAFKA_GROUP"),
+		clientID:    os.Getenv("KAFKA_CLIENT_ID"),
+		batchSize:   int(envInt64("KAFKA_BATCH_SIZE", 1000)),
+		lagInterval: envDuration("KAFKA_LAG_INTERVAL", 15*time.Second),
+	}
+	if len(cfg.topics) == 0 {
+		cfg.topics = []string{"telemetry"}
+	}
+	if cfg.group == "" {
+		cfg.group = "telemetry-dashboard"
+	}
+	if cfg.clientID == "" {
+		cfg.clientID = "telemetry-dashboard"
+	}
+	return cfg
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// KafkaPartitionLag is the consumer lag of one partition.
+type KafkaPartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"` // -1 when the group has no commit yet
+	End       int64  `json:"end"`
+	Lag       int64  `json:"lag"` // -1 when it could not be computed
+	Error     string `json:"error,omitempty"`
+}
+
+// KafkaConsumerStatus reports the consumer progress and the group lag.
+type KafkaConsumerStatus struct {
+	Group      string              `json:"group"`
+	Topics     []string            `json:"topics"`
+	Consumed   int64               `json:"consumed"` // messages committed
+	Inserted   int64               `json:"inserted"`
+	Skipped    int64               `json:"skipped"` // rows already stored, e.g. replays
+	Rejected   int64               `json:"rejected"`
+	TotalLag   int64               `json:"total_lag"`
+	Partitions []KafkaPartitionLag `json:"partitions"`
+	LagError   string              `json:"lag_error,omitempty"`
+	LagAt      *time.Time          `json:"lag_at,omitempty"`
+}
+
+type kafkaConsumer struct {
+	pool   *pgxpool.Pool
+	cfg    kafkaConfig
+	client *kgo.Client
+
+	mu     sync.Mutex
+	status KafkaConsumerStatus
+}
+
+// kafkaStatus is the running consumer, nil when it is disabled.
+var kafkaStatus struct {
+	sync.Mutex
+	consumer *kafkaConsumer
+}
+
+// StartKafkaConsumer joins the configured consumer group and writes the
+// consumed telemetry, one poll per batch. Offsets are committed only after
+// the batch is written, giving at-least-once delivery; replayed rows are
+// skipped by the telemetry primary key. The consumer stops when ctx is done.
+// It is disabled unless KAFKA_BROKERS is set.
+func StartKafkaConsumer(ctx context.Context, pool *pgxpool.Pool) error {
+	cfg := kafkaConfigFromEnv()
+	if len(cfg.brokers) == 0 {
+		slog.Info("kafka consumer disabled")
+		return nil
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(cfg.brokers...),
+		kgo.ClientID(cfg.clientID),
+		kgo.ConsumerGroup(cfg.group),
+		kgo.ConsumeTopics(cfg.topics...),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.DisableAutoCommit(),
+		// Hold partitions until the polled batch is committed
+		kgo.BlockRebalanceOnPoll(),
+	)
+	if err != nil {
+		return err
+	}
+
+	k := &kafkaConsumer{
+		pool:   pool,
+		cfg:    cfg,
+		client: client,
+		status: KafkaConsumerStatus{Group: cfg.group, Topics: cfg.topics, Partitions: []KafkaPartitionLag{}},
+	}
+	kafkaStatus.Lock()
+	kafkaStatus.consumer = k
+	kafkaStatus.Unlock()
+
+	go k.run(ctx)
+	go k.watchLag(ctx)
+
+	slog.Info("kafka consumer started", "brokers", cfg.brokers, "topics", cfg.topics, "group", cfg.group)
+	return nil
+}
+
+// run polls batches of up to batchSize messages and commits their offsets
+// once written. The message key, when set, is the vehicle ID. Once ctx is
+// done it closes the client; a batch that was not written by then is left
+// uncommitted and consumed again after a restart.
+func (k *kafkaConsumer) run(ctx context.Context) {
+	defer func() {
+		k.client.AllowRebalance()
+		k.client.Close()
+		slog.Info("kafka consumer stopped")
+	}()
+	for {
+		fetches := k.client.PollRecords(ctx, k.cfg.batchSize)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			slog.Error("kafka fetch failed", "topic", topic, "partition", partition, "error", err)
+		})
+
+		if recs := fetches.Records(); len(recs) > 0 {
+			sb := newStreamBatch("kafka")
+			for _, r := range recs {
+				sb.add(r.Value, string(r.Key), "topic", r.Topic, "partition", r.Partition, "offset", r.Offset)
+			}
+			res, err := sb.write(ctx, k.pool)
+			if err == nil {
+				// A written batch is committed even while shutting down,
+				// so that it is not consumed again
+				cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+				err = k.client.CommitRecords(cctx, recs...)
+				cancel()
+			}
+			if err != nil {
+				// Uncommitted messages are consumed again after a rebalance or restart
+				slog.Error("kafka offset commit failed", "messages", len(recs), "error", err)
+			} else {
+				k.mu.Lock()
+				k.status.Consumed += int64(len(recs))
+				k.status.Inserted += res.Inserted
+				k.status.Skipped += res.Skipped
+				k.status.Rejected += int64(sb.rejected)
+				k.mu.Unlock()
+				slog.Debug("kafka batch committed", "messages", len(recs), "inserted", res.Inserted, "skipped", res.Skipped, "rejected", sb.rejected)
+			}
+		}
+		k.client.AllowRebalance()
+	}
+}
+
+// watchLag refreshes the group lag every lagInterval until ctx is done.
+func (k *kafkaConsumer) watchLag(ctx context.Context) {
+	admin := kadm.NewClient(k.client)
+	ticker := time.NewTicker(k.cfg.lagInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		lags, err := admin.Lag(lctx, k.cfg.group)
+		cancel()
+
+		now := time.Now().UTC()
+		partitions := []KafkaPartitionLag{}
+		var total int64
+		if err == nil {
+			err = lags.Error()
+		}
+		if err == nil {
+			for _, l := range lags[k.cfg.group].Lag.Sorted() {
+				p := KafkaPartitionLag{Topic: l.Topic, Partition: l.Partition, Committed: l.Commit.At, End: l.End.Offset, Lag: l.Lag}
+				if l.Err != nil {
+					p.Error = l.Err.Error()
+				}
+				if l.Lag > 0 {
+					total += l.Lag
+				}
+				partitions = append(partitions, p)
+			}
+		}
+
+		k.mu.Lock()
+		k.status.LagAt = &now
+		if err != nil {
+			k.status.LagError = err.Error()
+		} else {
+			k.status.LagError = ""
+			k.status.TotalLag = total
+			k.status.Partitions = partitions
+		}
+		k.mu.Unlock()
+
+		if err != nil {
+			slog.Warn("kafka lag unavailable", "group", k.cfg.group, "error", err)
+			continue
+		}
+		slog.Info("kafka consumer lag", "group", k.cfg.group, "total_lag", total, "partitions", len(partitions))
+	}
+}
+
+// GetKafkaLag reports the Kafka consumer progress and its last computed lag.
+func GetKafkaLag(c *gin.Context) {
+	kafkaStatus.Lock()
+	k := kafkaStatus.consumer
+	kafkaStatus.Unlock()
+	if k == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "kafka consumer is disabled"})
+		return
+	}
+
+	k.mu.Lock()
+	status := k.status
+	k.mu.Unlock()
+	c.JSON(http.StatusOK, status)
+}
+
+// KafkaConsumerEnabled reports whether KAFKA_BROKERS is set.
+func KafkaConsumerEnabled() bool {
+	return len(splitList(os.Getenv("KAFKA_BROKERS"))) > 0
+}
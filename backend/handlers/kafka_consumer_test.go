@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestKafkaConfigFromEnv(t *testing.T) {
+	cfg := kafkaConfigFromEnv()
+	if cfg.brokers != nil || !reflect.DeepEqual(cfg.topics, []string{"telemetry"}) || cfg.group != "telemetry-dashboard" {
+		t.Errorf("defaults = %+v", cfg)
+	}
+
+	t.Setenv("KAFKA_BROKERS", "kafka-1:9092, kafka-2:9092,")
+	t.Setenv("KAFKA_TOPICS", "fleet.telemetry")
+	t.Setenv("KAFKA_GROUP", "dashboard-eu")
+	t.Setenv("KAFKA_LAG_INTERVAL", "1m")
+	cfg = kafkaConfigFromEnv()
+	if !reflect.DeepEqual(cfg.brokers, []string{"kafka-1:9092", "kafka-2:9092"}) || cfg.topics[0] != "fleet.telemetry" {
+		t.Errorf("brokers %q, topics %q", cfg.brokers, cfg.topics)
+	}
+	if cfg.group != "dashboard-eu" || cfg.clientID != "telemetry-dashboard" || cfg.lagInterval != time.Minute {
+		t.Errorf("cfg = %+v", cfg)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got := splitList(" a ,,b, "); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("splitList = %q", got)
+	}
+	if got := splitList(""); got != nil {
+		t.Errorf("splitList(\"\") = %q, want nil", got)
+	}
+}
+
+func TestKafkaConsumerShutdown(t *testing.T) {
+	// Nothing listens on the seed broker; the client only dials once used
+	client, err := kgo.NewClient(kgo.SeedBrokers("127.0.0.1:1"), kgo.ConsumeTopics("telemetry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &kafkaConsumer{client: client, cfg: kafkaConfig{group: "test", batchSize: 10, lagInterval: time.Millisecond}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		k.run(ctx)
+		close(done)
+	}()
+	lagDone := make(chan struct{})
+	go func() {
+		k.watchLag(ctx)
+		close(lagDone)
+	}()
+	cancel()
+
+	for name, ch := range map[string]chan struct{}{"run": done, "watchLag": lagDone} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not stop", name)
+		}
+	}
+	if !client.PollRecords(context.Background(), 1).IsClientClosed() {
+		t.Error("client was not closed")
+	}
+}
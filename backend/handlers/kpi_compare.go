@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Baselines of the compare parameter of /kpis.
+const (
+	comparePreviousPeriod = "previous_period"
+	compareLastYear       = "same_period_last_year"
+	compareCustom         = "custom"
+)
+
+// KpiWindow holds the KPIs of one time window.
+type KpiWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	KPIs  KpiValues `json:"kpis"`
+}
+
+// KpiComparison compares the KPIs of the requested window with a baseline.
+type KpiComparison struct {
+	VehicleID string    `json:"vehicle_id,omitempty"` // with group_by=vehicle
+	Current   KpiWindow `json:"current"`
+	Baseline  KpiWindow `json:"baseline"`
+	Delta     KpiValues `json:"delta"`     // current - baseline
+	DeltaPct  KpiValues `json:"delta_pct"` // relative to the baseline, null when it is 0
+}
+
+// baselineFilters returns the filters of the baseline window.
+func baselineFilters(c *gin.Context, mode string, current *QueryFilters) (*QueryFilters, error) {
+	base := *current
+	switch mode {
+	case comparePreviousPeriod:
+		// The window right before, ending just before the current one starts
+		base.Start = current.Start.Add(-current.End.Sub(current.Start))
+		base.End = current.Start.Add(-time.Microsecond)
+	case compareLastYear:
+		base.Start = current.Start.AddDate(-1, 0, 0)
+		base.End = current.End.AddDate(-1, 0, 0)
+	case compareCustom:
+		start, err1 := time.Parse(time.RFC3339, strings.TrimSpace(c.Query("baseline_start")))
+		end, err2 := time.Parse(time.RFC3339, strings.TrimSpace(c.Query("baseline_end")))
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("compare=custom requires baseline_start and baseline_end (RFC3339)")
+		}
+		if start.After(end) {
+			return nil, fmt.Errorf("baseline_end cannot come before baseline_start")
+		}
+		base.Start, base.End = start, end
+	default:
+		return nil, fmt.Errorf("invalid compare %q (previous_period, same_period_last_year or custom)", mode)
+	}
+	return &base, nil
+}
+
+// compareKPIs computes kpis for the current and the baseline window in
+// parallel and pairs them up, pooled (one result) or per vehicle.
+func compareKPIs(ctx context.Context, pool *pgxpool.Pool, current, baseline *QueryFilters, kpis []*KPI, perVehicle bool) ([]KpiComparison, error) {
+	var wg sync.WaitGroup
+	var cur, base []VehicleKpis
+	var curErr, baseErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		cur, curErr = queryKPIs(ctx, pool, current, kpis, perVehicle)
+	}()
+	go func() {
+		defer wg.Done()
+		base, baseErr = queryKPIs(ctx, pool, baseline, kpis, perVehicle)
+	}()
+	wg.Wait()
+	if curErr != nil {
+		return nil, curErr
+	}
+	if baseErr != nil {
+		return nil, baseErr
+	}
+
+	// Vehicles of either window, current ones first
+	var vehicles []string
+	curBy := make(map[string]KpiValues)
+	baseBy := make(map[string]KpiValues)
+	for _, v := range cur {
+		vehicles = append(vehicles, v.VehicleID)
+		curBy[v.VehicleID] = v.KPIs
+	}
+	for _, v := range base {
+		if _, ok := curBy[v.VehicleID]; !ok {
+			vehicles = append(vehicles, v.VehicleID)
+		}
+		baseBy[v.VehicleID] = v.KPIs
+	}
+
+	out := []KpiComparison{}
+	for _, vehicle := range vehicles {
+		cmp := KpiComparison{
+			VehicleID: vehicle,
+			Current:   KpiWindow{Start: current.Start, End: current.End, KPIs: make(KpiValues, len(kpis))},
+			Baseline:  KpiWindow{Start: baseline.Start, End: baseline.End, KPIs: make(KpiValues, len(kpis))},
+			Delta:     make(KpiValues, len(kpis)),
+			DeltaPct:  make(KpiValues, len(kpis)),
+		}
+		for _, k := range kpis {
+			c, b := curBy[vehicle][k.ID], baseBy[vehicle][k.ID]
+			cmp.Current.KPIs[k.ID], cmp.Baseline.KPIs[k.ID] = c, b
+			cmp.Delta[k.ID], cmp.DeltaPct[k.ID] = nil, nil
+			if c == nil || b == nil {
+				continue
+			}
+			delta := *c - *b
+			cmp.Delta[k.ID] = &delta
+			if *b != 0 {
+				pct := delta / math.Abs(*b) * 100
+				cmp.DeltaPct[k.ID] = &pct
+			}
+		}
+		out = append(out, cmp)
+	}
+	return out, nil
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBaselineFilters(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	current := &QueryFilters{VehicleIDs: []string{"B183"}, Start: day(2020, 3, 1), End: day(2020, 3, 8)}
+
+	prev, err := baselineFilters(queryContext(""), comparePreviousPeriod, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prev.Start.Equal(day(2020, 2, 23)) || !prev.End.Equal(day(2020, 3, 1).Add(-time.Microsecond)) {
+		t.Errorf("previous period = %v – %v", prev.Start, prev.End)
+	}
+	if prev.VehicleIDs[0] != "B183" || !current.Start.Equal(day(2020, 3, 1)) {
+		t.Error("baseline must keep the vehicles and leave the current window alone")
+	}
+
+	leap := &QueryFilters{Start: day(2020, 2, 29), End: day(2020, 3, 1)}
+	year, err := baselineFilters(queryContext(""), compareLastYear, leap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Go normalizes 2019-02-29 to March 1st
+	if !year.Start.Equal(day(2019, 3, 1)) || !year.End.Equal(day(2019, 3, 1)) {
+		t.Errorf("last year = %v – %v", year.Start, year.End)
+	}
+
+	custom, err := baselineFilters(queryContext("baseline_start=2019-01-01T00:00:00Z&baseline_end=2019-01-31T00:00:00Z"), compareCustom, current)
+	if err != nil || !custom.Start.Equal(day(2019, 1, 1)) || !custom.End.Equal(day(2019, 1, 31)) {
+		t.Errorf("custom = %+v, %v", custom, err)
+	}
+
+	for _, bad := range []struct{ mode, query string }{
+		{compareCustom, "baseline_start=2019-01-01T00:00:00Z"},
+		{compareCustom, "baseline_start=2019-02-01T00:00:00Z&baseline_end=2019-01-01T00:00:00Z"},
+		{"yesterday", ""},
+	} {
+		if _, err := baselineFilters(queryContext(bad.query), bad.mode, current); err == nil {
+			t.Errorf("compare=%s with %q accepted", bad.mode, bad.query)
+		}
+	}
+}
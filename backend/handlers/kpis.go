@@ -2,66 +2,285 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Thresholds of the motion KPIs.
+const (
+	idleSpeed       = 0.5  // m/s, below counts as standing
+	harshBrakeDecel = -2.5 // m/s², at or below is a harsh-braking sample
+)
+
+// Unit conversions from the per-second integrals.
+const (
+	joulesPerKWh   = 3.6e6
+	metersPerKm    = 1000.0
+	secondsPerHour = 3600.0
+)
+
+const kpiQueryTimeout = 10 * time.Second
+
+// kpiMaxGap is the longest interval between two samples that is integrated;
+// longer ones count as missing data instead of being interpolated.
+var kpiMaxGap = envDuration("KPI_MAX_SAMPLE_GAP", 30*time.Second)
+
+// kpiTelemetryCols are the telemetry columns the KPI expressions read.
+const kpiTelemetryCols = "vehicle_id, time_iso, odometry_vehicle_speed, electric_power_demand, temperature_ambient, " +
+	"traction_brake_pressure, traction_traction_force, status_door_is_open, itcs_number_of_passengers"
+
+const kpiSampleWindow = "PARTITION BY vehicle_id ORDER BY time_iso"
+
+// KPI is a key performance indicator computed over the samples of a time
+// window. Expressions are aggregates over kpiSamplesQuery, which adds to
+// every sample its duration dt (seconds until the next sample of the same
+// vehicle, NULL past kpiMaxGap), the next power value, its acceleration and
+// the previous door and passenger values.
+type KPI struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Unit        string `json:"unit"`
+	Description string `json:"description"`
+
+	expr string
+}
+
+// kpiRegistry lists the available KPIs, in display order.
+var kpiRegistry = []KPI{
+	{ID: "avg_speed", Name: "Average Speed", Unit: "m/s", Description: "Mean of the speed samples",
+		expr: "AVG(odometry_vehicle_speed)"},
+	{ID: "max_temp", Name: "Max Temperature", Unit: "°C", Description: "Highest ambient temperature",
+		expr: "MAX(temperature_ambient)"},
+	{ID: "total_power", Name: "Total Power", Unit: "W", Description: "Sum of the power samples, depends on the sampling rate; use energy_charged_kwh",
+		expr: "SUM(electric_power_demand)"},
+	{ID: "avg_brake_pressure", Name: "Average Brake Pressure", Unit: "Pa", Description: "Mean of the brake pressure samples",
+		expr: "AVG(traction_brake_pressure)"},
+	{ID: "door_open_ratio", Name: "Door Open Ratio", Unit: "", Description: "Share of samples with a door open",
+		expr: "AVG(status_door_is_open)"},
+	{ID: "distance_km", Name: "Distance Driven", Unit: "km", Description: "Speed integrated over time",
+		expr: fmt.Sprintf("SUM(odometry_vehicle_speed * dt) / %g", metersPerKm)},
+	{ID: "energy_kwh", Name: "Net Energy", Unit: "kWh", Description: "Energy charged minus energy regenerated",
+		expr: fmt.Sprintf("SUM(%s - %s) / %g", chargedEnergy, regeneratedEnergy, joulesPerKWh)},
+	{ID: "energy_charged_kwh", Name: "Energy Charged", Unit: "kWh", Description: "Positive power demand integrated over time",
+		expr: fmt.Sprintf("SUM(%s) / %g", chargedEnergy, joulesPerKWh)},
+	{ID: "energy_regenerated_kwh", Name: "Energy Regenerated", Unit: "kWh", Description: "Negative power demand integrated over time",
+		expr: fmt.Sprintf("SUM(%s) / %g", regeneratedEnergy, joulesPerKWh)},
+	{ID: "regen_share", Name: "Regenerative Share", Unit: "", Description: "Energy regenerated relative to the energy charged",
+		expr: fmt.Sprintf("SUM(%s) / NULLIF(SUM(%s), 0)", regeneratedEnergy, chargedEnergy)},
+	{ID: "idle_time_h", Name: "Idle Time", Unit: "h", Description: fmt.Sprintf("Time standing (speed below %g m/s)", idleSpeed),
+		expr: fmt.Sprintf("SUM(dt) FILTER (WHERE odometry_vehicle_speed < %g) / %g", idleSpeed, secondsPerHour)},
+	{ID: "moving_time_h", Name: "Time Moving", Unit: "h", Description: fmt.Sprintf("Time moving (speed of %g m/s or more)", idleSpeed),
+		expr: fmt.Sprintf("SUM(dt) FILTER (WHERE odometry_vehicle_speed >= %g) / %g", idleSpeed, secondsPerHour)},
+	{ID: "passengers_carried", Name: "Passengers Carried", Unit: "", Description: "Sum of the increases of the passenger count (boardings)",
+		expr: "SUM(GREATEST(itcs_number_of_passengers - prev_passengers, 0))"},
+	{ID: "stop_count", Name: "Stops", Unit: "", Description: "Number of door openings",
+		expr: "COUNT(*) FILTER (WHERE status_door_is_open = 1 AND prev_door_open = 0)"},
+	{ID: "harsh_brake_events", Name: "Harsh-Braking Events", Unit: "", Description: fmt.Sprintf("Decelerations reaching %g m/s²", -harshBrakeDecel),
+		expr: "COUNT(*) FILTER (WHERE harsh AND NOT COALESCE(prev_harsh, false))"},
+	{ID: "avg_traction_force", Name: "Average Traction Force", Unit: "N", Description: "Mean of the traction force samples",
+		expr: "AVG(traction_traction_force)"},
+	{ID: "missing_time_h", Name: "Missing Data", Unit: "h", Description: "Time in gaps between samples that are not integrated",
+		expr: fmt.Sprintf("SUM(missing) / %g", secondsPerHour)},
+}
+
+// Energy of a sample interval in joules, integrating the power demand
+// linearly between the sample and the next one (like time_weight('Linear')
+// of the TimescaleDB Toolkit). An interval whose power changes sign is split
+// at the zero crossing. NULL past kpiMaxGap or without both power values.
+const (
+	chargedEnergy = `CASE
+		WHEN electric_power_demand >= 0 AND next_power >= 0 THEN (electric_power_demand + next_power) / 2 * dt
+		WHEN electric_power_demand <= 0 AND next_power <= 0 THEN 0 * dt
+		ELSE power(GREATEST(electric_power_demand, next_power), 2) / (2 * abs(electric_power_demand - next_power)) * dt END`
+	regeneratedEnergy = `CASE
+		WHEN electric_power_demand <= 0 AND next_power <= 0 THEN -(electric_power_demand + next_power) / 2 * dt
+		WHEN electric_power_demand >= 0 AND next_power >= 0 THEN 0 * dt
+		ELSE power(LEAST(electric_power_demand, next_power), 2) / (2 * abs(electric_power_demand - next_power)) * dt END`
+)
+
+var kpisByID = func() map[string]*KPI {
+	m := make(map[string]*KPI, len(kpiRegistry))
+	for i := range kpiRegistry {
+		m[kpiRegistry[i].ID] = &kpiRegistry[i]
+	}
+	return m
+}()
+
+// kpiSamplesQuery selects the samples of the $1 vehicles between $2 and $3
+// with the per-sample values the KPI expressions build on.
+var kpiSamplesQuery = fmt.Sprintf(`
+	WITH raw AS (
+		SELECT %[1]s,
+		       EXTRACT(EPOCH FROM LEAD(time_iso) OVER w - time_iso)::float8 AS gap,
+		       LEAD(electric_power_demand) OVER w AS next_power,
+		       (odometry_vehicle_speed - LAG(odometry_vehicle_speed) OVER w)
+		           / NULLIF(EXTRACT(EPOCH FROM time_iso - LAG(time_iso) OVER w), 0) AS accel,
+		       LAG(status_door_is_open) OVER w AS prev_door_open,
+		       LAG(itcs_number_of_passengers) OVER w AS prev_passengers
+		FROM telemetry
+		WHERE %[2]s
+		  AND time_iso >= $2::timestamptz
+		  AND time_iso <= $3::timestamptz
+		WINDOW w AS (%[3]s)
+	), samples AS (
+		SELECT *,
+		       CASE WHEN gap <= %[5]g THEN gap END AS dt,
+		       CASE WHEN gap > %[5]g THEN gap END AS missing,
+		       accel <= %[4]g AS harsh, LAG(accel <= %[4]g) OVER (%[3]s) AS prev_harsh
+		FROM raw
+	)
+`, kpiTelemetryCols, vehicleCondition, kpiSampleWindow, harshBrakeDecel, kpiMaxGap.Seconds())
+
+// parseKPIs reads the kpis parameter, a comma separated list of KPI IDs.
+// All KPIs are returned when it is empty.
+func parseKPIs(raw string) ([]*KPI, error) {
+	if strings.TrimSpace(raw) == "" {
+		out := make([]*KPI, len(kpiRegistry))
+		for i := range kpiRegistry {
+			out[i] = &kpiRegistry[i]
+		}
+		return out, nil
+	}
+	var out []*KPI
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(raw, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		k, ok := kpisByID[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown kpi: %s", id)
+		}
+		seen[id] = true
+		out = append(out, k)
+	}
+	return out, nil
+}
+
 func GetKPIs(c *gin.Context, pool *pgxpool.Pool) {
 	filters, valid := parseQueryFilters(c)
 	if !valid {
-		slog.Warn("invalid KPI request params", "vehicle", c.Query("vehicle_id"))
+		slog.Warn("invalid KPI request params", "vehicle", c.Query("vehicle_id"), "fleet", c.Query("fleet"))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
 		return
 	}
+	perVehicle, err := parseGroupBy(c)
+	if err != nil {
+		slog.Warn("invalid KPI params", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	kpis, err := parseKPIs(c.Query("kpis"))
+	if err != nil {
+		slog.Warn("invalid KPI params", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	slog.Info("handling KPI request",
-		"vehicle", filters.VehicleID, "start", filters.Start, "end", filters.End)
-
-	query := `
-        SELECT
-            AVG(odometry_vehicle_speed),     -- avg_speed
-            MAX(temperature_ambient),        -- max_temp
-            SUM(electric_power_demand),      -- total_power
-            AVG(traction_brake_pressure),    -- avg_brake_pressure
-            AVG(status_door_is_open)::float8 -- door_open_ratio
-        FROM telemetry
-        WHERE vehicle_id = $1
-          AND time_iso >= $2::timestamptz
-          AND time_iso <= $3::timestamptz
-    `
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		"vehicles", filters.label(), "per_vehicle", perVehicle, "kpis", len(kpis), "start", filters.Start, "end", filters.End)
+
+	ctx, cancel := context.WithTimeout(context.Background(), kpiQueryTimeout)
 	defer cancel()
 
-	var avgSpeed, maxTemp, totalPower, avgBrakePressure, doorOpenRatio *float64
-	err := pool.QueryRow(ctx, query, filters.VehicleID, filters.Start, filters.End).
-		Scan(&avgSpeed, &maxTemp, &totalPower, &avgBrakePressure, &doorOpenRatio)
+	if mode := c.Query("compare"); mode != "" {
+		baseline, err := baselineFilters(c, mode, filters)
+		if err != nil {
+			slog.Warn("invalid KPI params", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		out, err := compareKPIs(ctx, pool, filters, baseline, kpis, perVehicle)
+		if err != nil {
+			slog.Error("KPI comparison query failed", "error", err, "vehicles", filters.label())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+			return
+		}
+		slog.Info("successfully compared KPIs", "vehicles", filters.label(), "compare", mode,
+			"baseline_start", baseline.Start, "baseline_end", baseline.End)
+		if perVehicle {
+			c.JSON(http.StatusOK, out)
+			return
+		}
+		c.JSON(http.StatusOK, out[0])
+		return
+	}
 
+	out, err := queryKPIs(ctx, pool, filters, kpis, perVehicle)
 	if err != nil {
-		slog.Error("KPI query failed", "error", err, "vehicle", filters.VehicleID)
+		slog.Error("KPI query failed", "error", err, "vehicles", filters.label())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
 		return
 	}
 
-	slog.Info("successfully retrieved KPIs",
-		"vehicle", filters.VehicleID, "avg_speed", avgSpeed, "max_temp", maxTemp, "total_power", totalPower, "avg_brake_pressure", avgBrakePressure, "door_open_ratio", doorOpenRatio)
-	c.JSON(http.StatusOK, KpiResponse{
-		Avg_speed:          avgSpeed,
-		Max_temp:           maxTemp,
-		Total_power:        totalPower,
-		Avg_brake_pressure: avgBrakePressure,
-		Door_open_ratio:    doorOpenRatio,
-	})
+	slog.Info("successfully retrieved KPIs", "vehicles", filters.label(), "rows", len(out))
+	if perVehicle {
+		c.JSON(http.StatusOK, out)
+		return
+	}
+	c.JSON(http.StatusOK, out[0].KPIs)
 }
 
-type KpiResponse struct {
-	Avg_speed          *float64 `json:"avg_speed"`
-	Max_temp           *float64 `json:"max_temp"`
-	Total_power        *float64 `json:"total_power"`
-	Avg_brake_pressure *float64 `json:"avg_brake_pressure"`
-	Door_open_ratio    *float64 `json:"door_open_ratio"`
+// queryKPIs computes kpis pooled over the filtered vehicles (one result) or
+// per vehicle.
+func queryKPIs(ctx context.Context, pool *pgxpool.Pool, filters *QueryFilters, kpis []*KPI, perVehicle bool) ([]VehicleKpis, error) {
+	groupCol, groupBy := "NULL::text", ""
+	if perVehicle {
+		groupCol, groupBy = "vehicle_id", "GROUP BY vehicle_id ORDER BY vehicle_id"
+	}
+	exprs := make([]string, len(kpis))
+	for i, k := range kpis {
+		exprs[i] = fmt.Sprintf("(%s)::float8", k.expr)
+	}
+	query := fmt.Sprintf("%s SELECT %s, %s FROM samples %s", kpiSamplesQuery, groupCol, strings.Join(exprs, ", "), groupBy)
+
+	rows, err := pool.Query(ctx, query, filters.vehicleArg(), filters.Start, filters.End)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []VehicleKpis{}
+	for rows.Next() {
+		var vehicle *string
+		values := make([]*float64, len(kpis))
+		dest := []interface{}{&vehicle}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		v := VehicleKpis{KPIs: make(KpiValues, len(kpis))}
+		if vehicle != nil {
+			v.VehicleID = *vehicle
+		}
+		for i, k := range kpis {
+			v.KPIs[k.ID] = values[i]
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// ListKPIs returns the KPI registry.
+func ListKPIs(c *gin.Context) {
+	c.JSON(http.StatusOK, kpiRegistry)
+}
+
+// KpiValues maps KPI IDs to their values, null without data.
+type KpiValues map[string]*float64
+
+// VehicleKpis are the KPIs of one vehicle (group_by=vehicle).
+type VehicleKpis struct {
+	VehicleID string    `json:"vehicle_id"`
+	KPIs      KpiValues `json:"kpis"`
 }
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testPool connects to TEST_DATABASE_URL and skips the test when it is unset.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestParseKPIs(t *testing.T) {
+	all, err := parseKPIs(" ")
+	if err != nil || len(all) != len(kpiRegistry) {
+		t.Fatalf("empty kpis: %d KPIs, %v", len(all), err)
+	}
+	for i, k := range all {
+		if k != &kpiRegistry[i] {
+			t.Errorf("KPI %d is %s, want registry order", i, k.ID)
+		}
+	}
+
+	for raw, want := range map[string]string{
+		"distance_km":                       "distance_km",
+		" energy_kwh , distance_km":         "energy_kwh,distance_km",
+		"stop_count,,stop_count,avg_speed,": "stop_count,avg_speed",
+	} {
+		kpis, err := parseKPIs(raw)
+		if err != nil {
+			t.Errorf("%q: %v", raw, err)
+			continue
+		}
+		var ids []string
+		for _, k := range kpis {
+			ids = append(ids, k.ID)
+		}
+		if got := strings.Join(ids, ","); got != want {
+			t.Errorf("%q: %s, want %s", raw, got, want)
+		}
+	}
+
+	if _, err := parseKPIs("distance_km,fuel_l"); err == nil || !strings.Contains(err.Error(), "fuel_l") {
+		t.Errorf("unknown KPI: err = %v", err)
+	}
+}
+
+func TestKPIRegistry(t *testing.T) {
+	if len(kpisByID) != len(kpiRegistry) {
+		t.Fatalf("%d IDs for %d KPIs, IDs must be unique", len(kpisByID), len(kpiRegistry))
+	}
+	for _, k := range kpiRegistry {
+		if k.Name == "" || k.Description == "" || k.expr == "" {
+			t.Errorf("%s: name, description and expression are required", k.ID)
+		}
+		// Expressions are pasted into SQL; the %g constants must render as numbers
+		if strings.Contains(k.expr, "%!") || strings.Contains(k.Description, "%!") {
+			t.Errorf("%s: bad format verb in %q", k.ID, k.expr)
+		}
+	}
+}
+
+// TestEnergyIntegration evaluates the energy expressions of one sample
+// interval in the database.
+func TestEnergyIntegration(t *testing.T) {
+	pool := testPool(t)
+	query := "SELECT " + chargedEnergy + ", " + regeneratedEnergy +
+		" FROM (VALUES ($1::float8, $2::float8, $3::float8)) v(electric_power_demand, next_power, dt)"
+
+	for _, tt := range []struct {
+		power, next, dt      float64
+		charged, regenerated float64
+	}{
+		{100, 300, 2, 400, 0},
+		{-100, -300, 2, 0, 400},
+		{0, 0, 5, 0, 0},
+		// Sign change: split where the line crosses zero (after 0.75 s)
+		{300, -100, 1, 112.5, 12.5},
+		{-100, 300, 1, 112.5, 12.5},
+	} {
+		var charged, regenerated float64
+		if err := pool.QueryRow(context.Background(), query, tt.power, tt.next, tt.dt).Scan(&charged, &regenerated); err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(charged-tt.charged) > 1e-9 || math.Abs(regenerated-tt.regenerated) > 1e-9 {
+			t.Errorf("%g W → %g W over %g s: charged %g J, regenerated %g J, want %g J and %g J",
+				tt.power, tt.next, tt.dt, charged, regenerated, tt.charged, tt.regenerated)
+		}
+	}
+
+	// Intervals past kpiMaxGap have no dt and no energy
+	var charged, regenerated *float64
+	if err := pool.QueryRow(context.Background(), query, 100.0, 100.0, nil).Scan(&charged, &regenerated); err != nil {
+		t.Fatal(err)
+	}
+	if charged != nil || regenerated != nil {
+		t.Errorf("gap: charged %v, regenerated %v, want NULL", charged, regenerated)
+	}
+}
+
+func TestEnergyKPIs(t *testing.T) {
+	// The energy KPIs share one integration, so they always add up
+	for id, parts := range map[string][]string{
+		"energy_kwh":             {chargedEnergy, regeneratedEnergy},
+		"energy_charged_kwh":     {chargedEnergy},
+		"energy_regenerated_kwh": {regeneratedEnergy},
+		"regen_share":            {regeneratedEnergy, chargedEnergy},
+	} {
+		expr := kpisByID[id].expr
+		for _, part := range parts {
+			if !strings.Contains(expr, part) {
+				t.Errorf("%s does not use %.40q...", id, part)
+			}
+		}
+		if strings.Contains(expr, "electric_power_demand * dt") {
+			t.Errorf("%s integrates the left sample only: %s", id, expr)
+		}
+	}
+	if !strings.Contains(kpiSamplesQuery, "LEAD(electric_power_demand) OVER w AS next_power") {
+		t.Error("kpiSamplesQuery does not select next_power")
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
@@ -19,15 +20,9 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
-type TelemetryEvent struct {
-	VehicleID string   `json:"vehicle_id"`
-	TimeISO   string   `json:"time_iso"`
-	Speed     *float64 `json:"speed,omitempty"`
-	Temp      *float64 `json:"temp,omitempty"`
-	Power     *float64 `json:"power,omitempty"`
-	Traction  *float64 `json:"traction,omitempty"`
-	Brake     *float64 `json:"brake,omitempty"`
-}
+// TelemetryEvent is a telemetry_channel notification: the inserted row keyed
+// by column name.
+type TelemetryEvent map[string]json.RawMessage
 
 func sendWSError(conn *websocket.Conn, msg string) {
 	errMsg := map[string]interface{}{
@@ -41,7 +36,15 @@ func sendWSError(conn *websocket.Conn, msg string) {
 func LiveTrend(c *gin.Context, pool *pgxpool.Pool) {
 	vehicle := c.Query("vehicle_id")
 	metric := c.DefaultQuery("metric", "speed")
-	// TODO validate metric before upgrading to WebSocket
+	m, err := lookupMetric(metric)
+	if err == nil && !m.Live {
+		err = fmt.Errorf("metric %s is not available live", metric)
+	}
+	if err != nil {
+		slog.Warn("invalid live trend params", "metric", metric, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -168,32 +171,22 @@ func LiveTrend(c *gin.Context, pool *pgxpool.Pool) {
 			}
 
 			// Filter by vehicle
-			if vehicle != "" && ev.VehicleID != vehicle {
+			var vehicleID, timeISO string
+			_ = json.Unmarshal(ev["vehicle_id"], &vehicleID)
+			if vehicle != "" && vehicleID != vehicle {
 				continue
 			}
 
 			// Pick metric value
 			var value *float64
-			switch metric {
-			case "speed":
-				value = ev.Speed
-			case "temp":
-				value = ev.Temp
-			case "power":
-				value = ev.Power
-			case "traction":
-				value = ev.Traction
-			case "brake":
-				value = ev.Brake
-			}
-
-			if value == nil {
+			if err := json.Unmarshal(ev[m.Column], &value); err != nil || value == nil {
 				continue
 			}
+			_ = json.Unmarshal(ev["time_iso"], &timeISO)
 
 			point := map[string]interface{}{
 				"type":      "point",
-				"timestamp": ev.TimeISO,
+				"timestamp": timeISO,
 				"value":     *value,
 			}
 
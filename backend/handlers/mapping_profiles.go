@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"telemetry-dashboard/my_structs"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultProfileName is used when an upload does not name a profile.
+const defaultProfileName = "ztbus"
+
+// MappingProfile describes how a vendor CSV maps onto the telemetry table.
+type MappingProfile struct {
+	Name string `json:"name"`
+	// Delimiter is the field separator, "," when empty.
+	Delimiter string `json:"delimiter,omitempty"`
+	// Columns maps CSV header names (aliases) to telemetry DB columns.
+	// Several aliases may point to the same DB column.
+	Columns map[string]string `json:"columns"`
+	// Optional lists DB columns that may be missing from the file.
+	Optional []string `json:"optional,omitempty"`
+	// Conversions are applied to numeric DB columns as value*scale + offset.
+	Conversions map[string]UnitConversion `json:"conversions,omitempty"`
+	// NullValues are cell values stored as NULL, "NaN" and "-" when empty.
+	NullValues []string `json:"null_values,omitempty"`
+	// IgnoreUnknown skips CSV columns that have no mapping instead of
+	// rejecting the file.
+	IgnoreUnknown bool `json:"ignore_unknown,omitempty"`
+	// FilenamePattern is a regular expression with a named group "vehicle"
+	// that extracts the vehicle ID from the uploaded file name. Optional
+	// "start" and "end" groups carry the declared mission range.
+	FilenamePattern string `json:"filename_pattern,omitempty"`
+	// FilenameTimeLayout is the Go time layout of the start/end groups,
+	// interpreted as UTC.
+	FilenameTimeLayout string `json:"filename_time_layout,omitempty"`
+
+	filenameRE *regexp.Regexp // FilenamePattern, compiled by validate
+}
+
+type UnitConversion struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+func (u UnitConversion) apply(v float64) float64 {
+	return v*u.Scale + u.Offset
+}
+
+// telemetryColumnTypes maps each telemetry DB column (except vehicle_id) to
+// the Go type of the matching my_structs.Telemetry field.
+var telemetryColumnTypes = func() map[string]reflect.Type {
+	typ := reflect.TypeOf(my_structs.Telemetry{})
+	out := make(map[string]reflect.Type, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		col := field.Tag.Get("db")
+		if col == "" || col == "vehicle_id" {
+			continue
+		}
+		out[col] = field.Type
+	}
+	return out
+}()
+
+func isNumericColumn(col string) bool {
+	typ, ok := telemetryColumnTypes[col]
+	return ok && typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Float64
+}
+
+func (p *MappingProfile) delimiter() rune {
+	if p.Delimiter == "" {
+		return ','
+	}
+	r, _ := utf8.DecodeRuneInString(p.Delimiter)
+	return r
+}
+
+func (p *MappingProfile) isNull(val string) bool {
+	nulls := p.NullValues
+	if len(nulls) == 0 {
+		nulls = []string{"NaN", "-"}
+	}
+	for _, n := range nulls {
+		if val == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *MappingProfile) isOptional(col string) bool {
+	for _, o := range p.Optional {
+		if o == col {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *MappingProfile) mapsColumn(col string) bool {
+	for _, c := range p.Columns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// filenameRegexp returns the compiled FilenamePattern, or nil if unset.
+func (p *MappingProfile) filenameRegexp() *regexp.Regexp {
+	return p.filenameRE
+}
+
+func (p *MappingProfile) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("profile name cannot be empty")
+	}
+	if p.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(p.Delimiter)
+		if size != len(p.Delimiter) || r == '"' || r == '\r' || r == '\n' {
+			return fmt.Errorf("invalid delimiter: %q", p.Delimiter)
+		}
+	}
+	if len(p.Columns) == 0 {
+		return fmt.Errorf("profile must map at least one column")
+	}
+
+	mapped := make(map[string]bool)
+	for header, col := range p.Columns {
+		if _, ok := telemetryColumnTypes[col]; !ok && col != "vehicle_id" {
+			return fmt.Errorf("column %q maps to unknown telemetry column %q", header, col)
+		}
+		mapped[col] = true
+	}
+	if !mapped["time_iso"] {
+		return fmt.Errorf("profile must map a column to time_iso")
+	}
+	for _, col := range p.Optional {
+		if col == "time_iso" {
+			return fmt.Errorf("time_iso cannot be optional")
+		}
+		if !mapped[col] {
+			return fmt.Errorf("optional column %q is not mapped", col)
+		}
+	}
+	for col, conv := range p.Conversions {
+		if !isNumericColumn(col) {
+			return fmt.Errorf("conversion on %q: only numeric columns can be converted", col)
+		}
+		if conv.Scale == 0 {
+			return fmt.Errorf("conversion on %q: scale cannot be zero", col)
+		}
+	}
+	if p.FilenamePattern != "" {
+		re, err := regexp.Compile(p.FilenamePattern)
+		if err != nil {
+			return fmt.Errorf("invalid filename_pattern: %w", err)
+		}
+		if re.SubexpIndex("vehicle") < 0 {
+			return fmt.Errorf("filename_pattern must contain a (?P<vehicle>...) group")
+		}
+		hasRange := re.SubexpIndex("start") >= 0 || re.SubexpIndex("end") >= 0
+		if hasRange && p.FilenameTimeLayout == "" {
+			return fmt.Errorf("filename_time_layout is required when filename_pattern has start/end groups")
+		}
+		p.filenameRE = re
+	}
+	return nil
+}
+
+// ztbusProfile is the built-in profile for ZTBus mission exports.
+var ztbusProfile = &MappingProfile{
+	Name:               defaultProfileName,
+	Columns:            csvHeaderToDb,
+	FilenamePattern:    ztbusFilenamePattern,
+	FilenameTimeLayout: ztbusFilenameTimeLayout,
+	filenameRE:         regexp.MustCompile(ztbusFilenamePattern),
+}
+
+type profileStore struct {
+	mu       sync.RWMutex
+	profiles map[string]*MappingProfile
+	path     string // file API changes are persisted to, if set
+}
+
+var mappingProfiles = &profileStore{
+	profiles: map[string]*MappingProfile{defaultProfileName: ztbusProfile},
+}
+
+// LoadMappingProfiles loads additional profiles from the JSON file named by
+// INGEST_PROFILES_FILE. The file holds an array of profiles; profiles created
+// through the API are written back to it.
+func LoadMappingProfiles() error {
+	path := os.Getenv("INGEST_PROFILES_FILE")
+	if path == "" {
+		return nil
+	}
+	mappingProfiles.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		slog.Info("mapping profile file not found, starting with built-in profiles", "path", path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read mapping profiles: %w", err)
+	}
+
+	var profiles []*MappingProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("parse mapping profiles: %w", err)
+	}
+
+	mappingProfiles.mu.Lock()
+	defer mappingProfiles.mu.Unlock()
+	for _, p := range profiles {
+		if p.Name == defaultProfileName {
+			return fmt.Errorf("profile %q is built in and cannot be redefined", p.Name)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		mappingProfiles.profiles[p.Name] = p
+	}
+
+	slog.Info("mapping profiles loaded", "path", path, "count", len(profiles))
+	return nil
+}
+
+// get resolves a profile by name; an empty name selects the default profile.
+func (s *profileStore) get(name string) (*MappingProfile, bool) {
+	if name == "" {
+		name = defaultProfileName
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.profiles[name]
+	return p, ok
+}
+
+func (s *profileStore) list() []*MappingProfile {
+	s.mu.RLock()
+	out := make([]*MappingProfile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		out = append(out, p)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// put stores p, replacing any profile of the same name. Stored profiles are
+// never mutated, so running ingests keep the version they started with.
+func (s *profileStore) put(p *MappingProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[p.Name] = p
+	return s.persistLocked()
+}
+
+func (s *profileStore) delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.profiles[name]; !ok {
+		return false, nil
+	}
+	delete(s.profiles, name)
+	return true, s.persistLocked()
+}
+
+// persistLocked writes all custom profiles to s.path. Caller holds s.mu.
+func (s *profileStore) persistLocked() error {
+	if s.path == "" {
+		return nil
+	}
+
+	custom := make([]*MappingProfile, 0, len(s.profiles))
+	for name, p := range s.profiles {
+		if name != defaultProfileName {
+			custom = append(custom, p)
+		}
+	}
+	sort.Slice(custom, func(i, j int) bool { return custom[i].Name < custom[j].Name })
+
+	data, err := json.MarshalIndent(custom, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+func ListMappingProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, mappingProfiles.list())
+}
+
+func GetMappingProfile(c *gin.Context) {
+	name := c.Param("name")
+	p, ok := mappingProfiles.get(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+func PutMappingProfile(c *gin.Context) {
+	name := c.Param("name")
+	if name == defaultProfileName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "built-in profile cannot be modified"})
+		return
+	}
+
+	var p MappingProfile
+	if err := c.ShouldBindJSON(&p); err != nil {
+		slog.Warn("invalid mapping profile body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile: " + err.Error()})
+		return
+	}
+	p.Name = name
+	if err := p.validate(); err != nil {
+		slog.Warn("invalid mapping profile", "profile", name, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := mappingProfiles.put(&p); err != nil {
+		slog.Error("failed to persist mapping profiles", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save profile"})
+		return
+	}
+
+	slog.Info("mapping profile saved", "profile", name)
+	c.JSON(http.StatusOK, p)
+}
+
+func DeleteMappingProfile(c *gin.Context) {
+	name := c.Param("name")
+	if name == defaultProfileName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "built-in profile cannot be deleted"})
+		return
+	}
+
+	found, err := mappingProfiles.delete(name)
+	if err != nil {
+		slog.Error("failed to persist mapping profiles", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save profiles"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+
+	slog.Info("mapping profile deleted", "profile", name)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMappingProfileValidate(t *testing.T) {
+	valid := func() *MappingProfile {
+		return &MappingProfile{
+			Name:        "vendor",
+			Delimiter:   ";",
+			Columns:     map[string]string{"ts": "time_iso", "v": "odometry_vehicle_speed", "t": "temperature_ambient"},
+			Optional:    []string{"temperature_ambient"},
+			Conversions: map[string]UnitConversion{"odometry_vehicle_speed": {Scale: 1 / 3.6}},
+		}
+	}
+	if err := valid().validate(); err != nil {
+		t.Fatalf("valid profile: %v", err)
+	}
+	if err := ztbusProfile.validate(); err != nil {
+		t.Fatalf("built-in profile: %v", err)
+	}
+
+	p := valid()
+	p.FilenamePattern = `^(?P<vehicle>\w+)\.csv$`
+	if p.filenameRegexp() != nil || p.validate() != nil {
+		t.Fatal("filename_pattern is compiled before validate, or invalid")
+	}
+	if re := p.filenameRegexp(); re == nil || re.FindStringSubmatch("B183.csv")[1] != "B183" {
+		t.Errorf("compiled filename_pattern = %v", re)
+	}
+
+	tests := []struct {
+		name   string
+		change func(p *MappingProfile)
+		want   string // part of the error
+	}{
+		{"empty name", func(p *MappingProfile) { p.Name = " " }, "name"},
+		{"multi-character delimiter", func(p *MappingProfile) { p.Delimiter = ";;" }, "delimiter"},
+		{"quote delimiter", func(p *MappingProfile) { p.Delimiter = `"` }, "delimiter"},
+		{"no columns", func(p *MappingProfile) { p.Columns = nil }, "at least one column"},
+		{"unknown column", func(p *MappingProfile) { p.Columns["x"] = "speed" }, "unknown telemetry column"},
+		{"no time_iso", func(p *MappingProfile) { delete(p.Columns, "ts") }, "time_iso"},
+		{"optional time_iso", func(p *MappingProfile) { p.Optional = []string{"time_iso"} }, "time_iso"},
+		{"optional not mapped", func(p *MappingProfile) { p.Optional = []string{"gnss_course"} }, "not mapped"},
+		{"conversion on a string", func(p *MappingProfile) {
+			p.Columns["stop"] = "itcs_stop_name"
+			p.Conversions["itcs_stop_name"] = UnitConversion{Scale: 2}
+		}, "numeric"},
+		{"zero scale", func(p *MappingProfile) {
+			p.Conversions["odometry_vehicle_speed"] = UnitConversion{Offset: 1}
+		}, "scale"},
+		{"invalid filename_pattern", func(p *MappingProfile) { p.FilenamePattern = "^(B[0-9]+" }, "filename_pattern"},
+		{"filename_pattern without vehicle", func(p *MappingProfile) { p.FilenamePattern = "^(B[0-9]+)_" }, "vehicle"},
+		{"range groups without layout", func(p *MappingProfile) {
+			p.FilenamePattern = `^(?P<vehicle>\w+)_(?P<start>\d{8})`
+		}, "filename_time_layout"},
+	}
+	for _, tt := range tests {
+		p := valid()
+		tt.change(p)
+		if err := p.validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestMappingProfileCells(t *testing.T) {
+	p := &MappingProfile{}
+	if p.delimiter() != ',' || !p.isNull("NaN") || !p.isNull("-") || p.isNull("") {
+		t.Error("defaults: want ',' delimiter and NaN and - as null")
+	}
+
+	p = &MappingProfile{Delimiter: "\t", NullValues: []string{"", "NULL"}}
+	if p.delimiter() != '\t' {
+		t.Errorf("delimiter = %q, want tab", p.delimiter())
+	}
+	if !p.isNull("") || !p.isNull("NULL") || p.isNull("NaN") {
+		t.Error("null_values replace the defaults")
+	}
+}
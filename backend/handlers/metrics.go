@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Value types of a metric.
+const (
+	metricFloat   = "float"
+	metricInteger = "integer"
+	metricBoolean = "boolean" // 0/1 status flag
+)
+
+// Metric describes a numeric telemetry column that can be queried.
+type Metric struct {
+	ID           string `json:"id"`
+	Column       string `json:"column"`
+	Name         string `json:"name"`
+	Unit         string `json:"unit"`
+	Type         string `json:"type"`
+	Aggregatable bool   `json:"aggregatable"` // bucket averages and extremes are meaningful
+	Live         bool   `json:"live"`         // available on /live-trend
+}
+
+// metricRegistry lists every queryable metric, in display order. A new
+// metric also needs its columns in the aggregate tiers of db/seed.sql.
+var metricRegistry = []Metric{
+	{ID: "speed", Column: "odometry_vehicle_speed", Name: "Speed", Unit: "m/s", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "power", Column: "electric_power_demand", Name: "Power Demand", Unit: "W", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "temp", Column: "temperature_ambient", Name: "Temperature", Unit: "°C", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "traction_force", Column: "traction_traction_force", Name: "Traction Force", Unit: "N", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "brake_pressure", Column: "traction_brake_pressure", Name: "Brake Pressure", Unit: "Pa", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "passengers", Column: "itcs_number_of_passengers", Name: "Passengers", Unit: "", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "altitude", Column: "gnss_altitude", Name: "Altitude", Unit: "m", Type: metricFloat, Aggregatable: true, Live: true},
+	// Bucket averages of a heading or a position are not meaningful
+	{ID: "course", Column: "gnss_course", Name: "Course", Unit: "°", Type: metricFloat, Live: true},
+	{ID: "latitude", Column: "gnss_latitude", Name: "Latitude", Unit: "°", Type: metricFloat, Live: true},
+	{ID: "longitude", Column: "gnss_longitude", Name: "Longitude", Unit: "°", Type: metricFloat, Live: true},
+	{ID: "articulation_angle", Column: "odometry_articulation_angle", Name: "Articulation Angle", Unit: "rad", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "steering_angle", Column: "odometry_steering_angle", Name: "Steering Angle", Unit: "rad", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "wheel_speed_fl", Column: "odometry_wheel_speed_fl", Name: "Wheel Speed Front Left", Unit: "rad/s", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "wheel_speed_fr", Column: "odometry_wheel_speed_fr", Name: "Wheel Speed Front Right", Unit: "rad/s", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "wheel_speed_ml", Column: "odometry_wheel_speed_ml", Name: "Wheel Speed Middle Left", Unit: "rad/s", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "wheel_speed_mr", Column: "odometry_wheel_speed_mr", Name: "Wheel Speed Middle Right", Unit: "rad/s", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "wheel_speed_rl", Column: "odometry_wheel_speed_rl", Name: "Wheel Speed Rear Left", Unit: "rad/s", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "wheel_speed_rr", Column: "odometry_wheel_speed_rr", Name: "Wheel Speed Rear Right", Unit: "rad/s", Type: metricFloat, Aggregatable: true, Live: true},
+	{ID: "door_open", Column: "status_door_is_open", Name: "Door Open", Unit: "", Type: metricBoolean, Aggregatable: true, Live: true},
+	{ID: "grid_available", Column: "status_grid_is_available", Name: "Grid Available", Unit: "", Type: metricBoolean, Aggregatable: true, Live: true},
+	{ID: "halt_brake", Column: "status_halt_brake_is_active", Name: "Halt Brake Active", Unit: "", Type: metricBoolean, Aggregatable: true, Live: true},
+	{ID: "park_brake", Column: "status_park_brake_is_active", Name: "Park Brake Active", Unit: "", Type: metricBoolean, Aggregatable: true, Live: true},
+}
+
+var metricsByID = func() map[string]*Metric {
+	m := make(map[string]*Metric, len(metricRegistry))
+	for i := range metricRegistry {
+		m[metricRegistry[i].ID] = &metricRegistry[i]
+	}
+	return m
+}()
+
+// lookupMetric returns the registered metric with the given ID.
+func lookupMetric(id string) (*Metric, error) {
+	if id == "" {
+		return nil, fmt.Errorf("metric cannot be empty")
+	}
+	m, ok := metricsByID[id]
+	if !ok {
+		return nil, fmt.Errorf("invalid metric: %s", id)
+	}
+	return m, nil
+}
+
+// ListMetrics returns the metric registry.
+func ListMetrics(c *gin.Context) {
+	slog.Debug("listing metrics", "count", len(metricRegistry))
+	c.JSON(http.StatusOK, metricRegistry)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestMetricRegistry keeps the registry in line with the telemetry table.
+func TestMetricRegistry(t *testing.T) {
+	if len(metricsByID) != len(metricRegistry) {
+		t.Fatalf("%d IDs for %d metrics, IDs must be unique", len(metricsByID), len(metricRegistry))
+	}
+	columns := make(map[string]string)
+	for _, m := range metricRegistry {
+		if other, ok := columns[m.Column]; ok {
+			t.Errorf("%s and %s both map to %s", other, m.ID, m.Column)
+		}
+		columns[m.Column] = m.ID
+
+		typ, ok := telemetryColumnTypes[m.Column]
+		if !ok || typ.Kind() != reflect.Ptr {
+			t.Errorf("%s: unknown or non-nullable column %s", m.ID, m.Column)
+			continue
+		}
+		switch kind := typ.Elem().Kind(); {
+		case m.Type == metricFloat && kind != reflect.Float64,
+			m.Type == metricBoolean && kind != reflect.Int,
+			m.Type == metricInteger && kind != reflect.Int && kind != reflect.Int64:
+			t.Errorf("%s: type %s on a %s column", m.ID, m.Type, kind)
+		}
+	}
+}
+
+func TestLookupMetric(t *testing.T) {
+	m, err := lookupMetric("speed")
+	if err != nil || m.Column != "odometry_vehicle_speed" {
+		t.Errorf("lookupMetric(speed) = %+v, %v", m, err)
+	}
+	for _, id := range []string{"", "Speed", "odometry_vehicle_speed", "speed; DROP TABLE telemetry"} {
+		if _, err := lookupMetric(id); err == nil {
+			t.Errorf("lookupMetric(%q) accepted the ID", id)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"telemetry-dashboard/my_structs"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// declaredMissionRange extracts the mission start and end encoded in a file
+// name through the profile's start/end pattern groups. Either may be nil.
+func declaredMissionRange(filename string, profile *MappingProfile) (start, end *time.Time) {
+	re := profile.filenameRegexp()
+	if re == nil || profile.FilenameTimeLayout == "" {
+		return nil, nil
+	}
+	m := re.FindStringSubmatch(filename)
+	if m == nil {
+		return nil, nil
+	}
+
+	parse := func(group string) *time.Time {
+		idx := re.SubexpIndex(group)
+		if idx < 0 || m[idx] == "" {
+			return nil
+		}
+		t, err := time.ParseInLocation(profile.FilenameTimeLayout, m[idx], time.UTC)
+		if err != nil {
+			slog.Warn("invalid mission time in filename", "filename", filename, "group", group, "value", m[idx])
+			return nil
+		}
+		return &t
+	}
+	return parse("start"), parse("end")
+}
+
+type missionStats struct {
+	first, last time.Time
+	rows        int64 // rows parsed and kept, see written
+
+	// Validation state, see ruleChecker.
+	prev         time.Time // latest timestamp so far
+	seen         bool
+	flaggedRows  int64 // kept rows with at least one finding
+	rejectedRows int64 // rows dropped by a rule
+	findingCount int64
+	findings     []my_structs.QualityFinding // first maxMissionFindings
+}
+
+// missionTracker collects the sample range and data-quality findings of every
+// vehicle seen in a file.
+type missionTracker map[string]*missionStats
+
+// get returns the stats of a vehicle, creating them on first use.
+func (m missionTracker) get(vehicle string) *missionStats {
+	st, ok := m[vehicle]
+	if !ok {
+		st = &missionStats{}
+		m[vehicle] = st
+	}
+	return st
+}
+
+func (m missionTracker) observe(vehicle string, ts time.Time) {
+	st := m.get(vehicle)
+	if st.rows == 0 {
+		st.first, st.last, st.rows = ts, ts, 1
+		return
+	}
+	if ts.Before(st.first) {
+		st.first = ts
+	}
+	if ts.After(st.last) {
+		st.last = ts
+	}
+	st.rows++
+}
+
+// recordMissions upserts one mission per vehicle of an ingested file together
+// with its data-quality findings. A re-upload of the same content refreshes
+// the existing mission instead of creating a duplicate. The row count of a
+// mission is the rows res wrote for its vehicle, which leaves out rows that
+// were skipped as duplicates.
+func recordMissions(ctx context.Context, tx pgx.Tx, meta uploadMeta, checksum string, missions missionTracker, res ingestResult) ([]int64, error) {
+	vehicles := make([]string, 0, len(missions))
+	for v := range missions {
+		vehicles = append(vehicles, v)
+	}
+	sort.Strings(vehicles)
+
+	ids := make([]int64, 0, len(vehicles))
+	for _, vehicle := range vehicles {
+		st := missions[vehicle]
+
+		// Vehicles whose rows were all rejected have no sample range
+		var first, last *time.Time
+		if st.rows > 0 {
+			first, last = &st.first, &st.last
+		}
+		written := st.rows
+		if res.written != nil {
+			written = res.written[vehicle]
+		}
+
+		var id int64
+		err := tx.QueryRow(ctx, `
+			INSERT INTO missions (vehicle_id, declared_start, declared_end, first_sample, last_sample,
+			                      row_count, source_filename, checksum,
+			                      quality_score, finding_count, rejected_rows)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (vehicle_id, checksum) DO UPDATE SET
+				declared_start  = EXCLUDED.declared_start,
+				declared_end    = EXCLUDED.declared_end,
+				first_sample    = EXCLUDED.first_sample,
+				last_sample     = EXCLUDED.last_sample,
+				row_count       = EXCLUDED.row_count,
+				source_filename = EXCLUDED.source_filename,
+				quality_score   = EXCLUDED.quality_score,
+				finding_count   = EXCLUDED.finding_count,
+				rejected_rows   = EXCLUDED.rejected_rows,
+				uploaded_at     = now()
+			RETURNING id
+		`, vehicle, meta.DeclaredStart, meta.DeclaredEnd, first, last,
+			written, meta.Filename, checksum,
+			st.qualityScore(), st.findingCount, st.rejectedRows).Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("record mission for %s: %w", vehicle, err)
+		}
+		if err := saveFindings(ctx, tx, id, st.findings); err != nil {
+			return nil, fmt.Errorf("record mission for %s: %w", vehicle, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+const missionColumns = `id, vehicle_id, declared_start, declared_end, first_sample, last_sample,
+	row_count, source_filename, uploaded_at, checksum, quality_score, finding_count, rejected_rows`
+
+func scanMission(row pgx.Row) (my_structs.Mission, error) {
+	var m my_structs.Mission
+	err := row.Scan(&m.ID, &m.VehicleID, &m.DeclaredStart, &m.DeclaredEnd, &m.FirstSample,
+		&m.LastSample, &m.RowCount, &m.SourceFilename, &m.UploadedAt, &m.Checksum,
+		&m.QualityScore, &m.FindingCount, &m.RejectedRows)
+	return m, err
+}
+
+// GetMissions lists ingested missions, newest first. Optional filters:
+// vehicle_id, start/end (RFC3339, missions overlapping the range), limit, offset.
+func GetMissions(c *gin.Context, pool *pgxpool.Pool) {
+	var where []string
+	var args []interface{}
+
+	if vehicle := strings.TrimSpace(c.Query("vehicle_id")); vehicle != "" {
+		args = append(args, vehicle)
+		where = append(where, fmt.Sprintf("vehicle_id = $%d", len(args)))
+	}
+	for _, p := range []struct{ param, cond string }{
+		{"start", "last_sample >= $%d"},
+		{"end", "first_sample <= $%d"},
+	} {
+		raw := strings.TrimSpace(c.Query(p.param))
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			slog.Warn("invalid missions params", p.param, raw)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time format, must be RFC3339: " + raw})
+			return
+		}
+		args = append(args, t)
+		where = append(where, fmt.Sprintf(p.cond, len(args)))
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		slog.Warn("invalid limit param, falling back to default", "limit", c.Query("limit"))
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	query := "SELECT " + missionColumns + " FROM missions"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY uploaded_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		slog.Error("missions query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	defer rows.Close()
+
+	out := []my_structs.Mission{}
+	for rows.Next() {
+		m, err := scanMission(rows)
+		if err != nil {
+			slog.Error("row scan failed inside missions", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
+			return
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("missions query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	slog.Info("missions query returned rows", "count", len(out))
+	c.JSON(http.StatusOK, out)
+}
+
+func GetMission(c *gin.Context, pool *pgxpool.Pool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mission id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m, err := scanMission(pool.QueryRow(ctx, "SELECT "+missionColumns+" FROM missions WHERE id = $1", id))
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "mission not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("mission query failed", "error", err, "id", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, m)
+}
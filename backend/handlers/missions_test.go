@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestDeclaredMissionRange(t *testing.T) {
+	at := func(h, m, s int) time.Time { return time.Date(2019, 6, 24, h, m, s, 0, time.UTC) }
+
+	start, end := declaredMissionRange("B183_2019-06-24_03-16-13_2019-06-24_18-54-06.csv", ztbusProfile)
+	if start == nil || end == nil || !start.Equal(at(3, 16, 13)) || !end.Equal(at(18, 54, 6)) {
+		t.Errorf("ZTBus file name: range = %v - %v", start, end)
+	}
+
+	// The range groups are optional in the ZTBus pattern
+	if start, end := declaredMissionRange("B183_export.csv", ztbusProfile); start != nil || end != nil {
+		t.Errorf("file name without range: %v - %v, want none", start, end)
+	}
+	// Matches the pattern, but 25 is not an hour
+	if start, _ := declaredMissionRange("B183_2019-06-24_25-16-13_2019-06-24_18-54-06.csv", ztbusProfile); start != nil {
+		t.Errorf("invalid start was parsed as %v", start)
+	}
+
+	noLayout := &MappingProfile{filenameRE: regexp.MustCompile(`^(?P<vehicle>\w+)_(?P<start>\d{8})`)}
+	if start, _ := declaredMissionRange("B183_20190624.csv", noLayout); start != nil {
+		t.Errorf("profile without filename_time_layout: start = %v", start)
+	}
+}
+
+func TestMissionTracker(t *testing.T) {
+	t0 := time.Date(2019, 6, 24, 3, 16, 0, 0, time.UTC)
+	m := missionTracker{}
+	for _, s := range []struct {
+		vehicle string
+		offset  time.Duration
+	}{{"B183", time.Minute}, {"B183", 0}, {"B208", time.Hour}, {"B183", 2 * time.Minute}, {"B183", time.Second}} {
+		m.observe(s.vehicle, t0.Add(s.offset))
+	}
+
+	if len(m) != 2 {
+		t.Fatalf("%d missions, want 2", len(m))
+	}
+	b183 := m["B183"]
+	if b183.rows != 4 || !b183.first.Equal(t0) || !b183.last.Equal(t0.Add(2*time.Minute)) {
+		t.Errorf("B183 = %d rows, %s - %s", b183.rows, b183.first, b183.last)
+	}
+	if b208 := m["B208"]; b208.rows != 1 || !b208.first.Equal(b208.last) {
+		t.Errorf("B208 = %+v", b208)
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jackc/pgx/v5/pgxpool"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// mqttConfig is read from the MQTT_* environment variables.
+type mqttConfig struct {
+	broker        string   // MQTT_BROKER_URL, e.g. tcp://mosquitto:1883; empty disables the bridge
+	topics        []string // MQTT_TOPICS, comma separated topic filters
+	clientID      string
+	username      string
+	password      string
+	qos           byte
+	batchSize     int
+	batchInterval time.Duration
+	embedded      string // MQTT_EMBEDDED_BROKER listen address, for local testing
+}
+
+func mqttConfigFromEnv() mqttConfig {
+	cfg := mqttConfig{
+		broker:        os.Getenv("MQTT_BROKER_URL"),
+		clientID:      os.Getenv("MQTT_CLIENT_ID"),
+		username:      os.Getenv("MQTT_USERNAME"),
+		password:      os.Getenv("MQTT_PASSWORD"),
+		qos:           byte(envInt64("MQTT_QOS", 1)),
+		batchSize:     int(envInt64("MQTT_BATCH_SIZE", 500)),
+		batchInterval: envDuration("MQTT_BATCH_INTERVAL", time.Second),
+		embedded:      os.Getenv("MQTT_EMBEDDED_BROKER"),
+	}
+	if cfg.clientID == "" {
+		cfg.clientID = "telemetry-dashboard"
+	}
+	if cfg.qos > 2 {
+		slog.Warn("invalid MQTT_QOS, using 1", "qos", cfg.qos)
+		cfg.qos = 1
+	}
+	topics := os.Getenv("MQTT_TOPICS")
+	if topics == "" {
+		topics = "fleet/+/telemetry"
+	}
+	cfg.topics = splitList(topics)
+	return cfg
+}
+
+// mqttEnqueueTimeout bounds how long a received message waits for room in
+// the buffer before it is dropped, see subscribe.
+var mqttEnqueueTimeout = 30 * time.Second
+
+// mqttMessage is a received message waiting for its batch to be committed.
+type mqttMessage struct {
+	msg     paho.Message
+	vehicle string // from the topic, "" when the filter has no wildcard
+}
+
+type mqttBridge struct {
+	ctx     context.Context
+	pool    *pgxpool.Pool
+	cfg     mqttConfig
+	client  paho.Client
+	msgs    chan mqttMessage
+	dropped atomic.Int64
+}
+
+// StartMQTTBridge subscribes to the configured MQTT topics and writes the
+// received telemetry in micro-batches. Messages are acknowledged only once
+// their batch is committed, so with QoS 1 the broker redelivers anything that
+// was not written. The bridge stops when ctx is done. It is disabled unless
+// MQTT_BROKER_URL or MQTT_EMBEDDED_BROKER is set.
+func StartMQTTBridge(ctx context.Context, pool *pgxpool.Pool) error {
+	cfg := mqttConfigFromEnv()
+
+	if cfg.embedded != "" {
+		addr, err := startEmbeddedBroker(cfg.embedded)
+		if err != nil {
+			return err
+		}
+		if cfg.broker == "" {
+			cfg.broker = "tcp://" + addr
+		}
+	}
+	if cfg.broker == "" {
+		slog.Info("mqtt bridge disabled")
+		return nil
+	}
+
+	b := &mqttBridge{ctx: ctx, pool: pool, cfg: cfg, msgs: make(chan mqttMessage, cfg.batchSize*2)}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.broker).
+		SetClientID(cfg.clientID).
+		SetUsername(cfg.username).
+		SetPassword(cfg.password).
+		// Keep the session so unacknowledged QoS 1 messages survive a reconnect
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(streamRetryMin).
+		SetMaxReconnectInterval(streamRetryMax).
+		SetOnConnectHandler(b.subscribe).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			slog.Warn("mqtt connection lost, reconnecting", "error", err)
+		})
+	b.client = paho.NewClient(opts)
+
+	// With ConnectRetry the token only completes once connected, the client
+	// keeps retrying in the background.
+	b.client.Connect()
+	go b.run()
+
+	slog.Info("mqtt bridge started", "broker", cfg.broker, "topics", cfg.topics, "qos", cfg.qos)
+	return nil
+}
+
+// subscribe (re)subscribes to every topic filter after each connect. Each
+// message is handled in its own goroutine (SetOrderMatters(false)), which
+// waits for room in the buffer while batches cannot be written: as messages
+// are acknowledged only after their batch, the broker stops sending once its
+// in-flight window is full. A message that still finds no room after
+// mqttEnqueueTimeout is dropped without an ack, and the bridge reconnects
+// once the buffer drained so that the broker redelivers it.
+func (b *mqttBridge) subscribe(client paho.Client) {
+	for _, filter := range b.cfg.topics {
+		level := vehicleTopicLevel(filter)
+		token := client.Subscribe(filter, b.cfg.qos, func(_ paho.Client, msg paho.Message) {
+			m := mqttMessage{msg: msg}
+			if level >= 0 {
+				if parts := strings.Split(msg.Topic(), "/"); level < len(parts) {
+					m.vehicle = parts[level]
+				}
+			}
+			b.enqueue(m)
+		})
+		if token.Wait() && token.Error() != nil {
+			slog.Error("mqtt subscribe failed", "topic", filter, "error", token.Error())
+			continue
+		}
+		slog.Info("mqtt subscribed", "topic", filter)
+	}
+}
+
+// enqueue hands a message to run, waiting at most mqttEnqueueTimeout.
+func (b *mqttBridge) enqueue(m mqttMessage) {
+	timer := time.NewTimer(mqttEnqueueTimeout)
+	defer timer.Stop()
+	select {
+	case b.msgs <- m:
+	case <-b.ctx.Done():
+	case <-timer.C:
+		if n := b.dropped.Add(1); n == 1 || n%1000 == 0 {
+			slog.Warn("mqtt buffer full, leaving messages unacknowledged", "dropped", n)
+		}
+	}
+}
+
+// vehicleTopicLevel returns the level of the first single-level wildcard of
+// a topic filter, which holds the vehicle ID, or -1.
+func vehicleTopicLevel(filter string) int {
+	for i, part := range strings.Split(filter, "/") {
+		if part == "+" {
+			return i
+		}
+	}
+	return -1
+}
+
+// run collects messages into batches of up to batchSize, flushing at least
+// every batchInterval. On shutdown the pending messages are left
+// unacknowledged for the broker to redeliver.
+func (b *mqttBridge) run() {
+	ticker := time.NewTicker(b.cfg.batchInterval)
+	defer ticker.Stop()
+
+	var batch []mqttMessage
+	for {
+		select {
+		case <-b.ctx.Done():
+			b.client.Disconnect(250)
+			slog.Info("mqtt bridge stopped", "unacknowledged", len(batch)+len(b.msgs))
+			return
+		case m := <-b.msgs:
+			batch = append(batch, m)
+			if len(batch) < b.cfg.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		b.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush writes a batch, retrying with backoff until it commits, then
+// acknowledges its messages. Nothing is acknowledged when the bridge stops
+// before the batch was written.
+func (b *mqttBridge) flush(batch []mqttMessage) {
+	sb := newStreamBatch("mqtt")
+	for _, m := range batch {
+		sb.add(m.msg.Payload(), m.vehicle, "topic", m.msg.Topic())
+	}
+	res, err := sb.write(b.ctx, b.pool)
+	if err != nil {
+		slog.Warn("mqtt batch not written, leaving it unacknowledged", "messages", len(batch), "error", err)
+		return
+	}
+	slog.Debug("mqtt batch written", "messages", len(batch), "inserted", res.Inserted, "skipped", res.Skipped, "rejected", sb.rejected)
+
+	for _, m := range batch {
+		m.msg.Ack()
+	}
+
+	// Dropped QoS 1 and 2 messages hold their slots in the broker's
+	// in-flight window until they are redelivered, which takes a reconnect
+	switch n := b.dropped.Swap(0); {
+	case n > 0 && b.cfg.qos > 0:
+		slog.Info("mqtt buffer drained, reconnecting for redelivery", "dropped", n)
+		b.client.Disconnect(250)
+		b.client.Connect()
+	case n > 0:
+		slog.Info("mqtt buffer drained", "dropped", n)
+	}
+}
+
+// startEmbeddedBroker runs an in-process MQTT broker without authentication,
+// meant for local testing without Mosquitto. It returns the listen address.
+func startEmbeddedBroker(addr string) (string, error) {
+	server := mochi.New(&mochi.Options{Logger: slog.Default()})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		return "", err
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "embedded", Address: addr})
+	if err := server.AddListener(tcp); err != nil {
+		return "", fmt.Errorf("embedded mqtt broker: %w", err)
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			slog.Error("embedded mqtt broker stopped", "error", err)
+		}
+	}()
+
+	// Connect through loopback when listening on all interfaces
+	host, port, err := net.SplitHostPort(tcp.Address())
+	if err != nil {
+		return "", err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	slog.Info("embedded mqtt broker started", "address", tcp.Address())
+	return net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVehicleTopicLevel(t *testing.T) {
+	for filter, want := range map[string]int{
+		"fleet/+/telemetry": 1,
+		"+/telemetry":       0,
+		"fleet/B183/+/raw":  2,
+		"fleet/+/+":         1,
+		"fleet/#":           -1,
+		"fleet/B183":        -1,
+	} {
+		if got := vehicleTopicLevel(filter); got != want {
+			t.Errorf("vehicleTopicLevel(%q) = %d, want %d", filter, got, want)
+		}
+	}
+}
+
+func TestMQTTConfigFromEnv(t *testing.T) {
+	t.Setenv("MQTT_BROKER_URL", "tcp://mosquitto:1883")
+	t.Setenv("MQTT_TOPICS", " fleet/+/telemetry, ,depot/+/telemetry")
+	t.Setenv("MQTT_QOS", "3")
+	t.Setenv("MQTT_BATCH_INTERVAL", "250ms")
+
+	cfg := mqttConfigFromEnv()
+	if !reflect.DeepEqual(cfg.topics, []string{"fleet/+/telemetry", "depot/+/telemetry"}) {
+		t.Errorf("topics = %q", cfg.topics)
+	}
+	if cfg.qos != 1 || cfg.clientID != "telemetry-dashboard" || cfg.batchSize != 500 || cfg.batchInterval != 250*time.Millisecond {
+		t.Errorf("cfg = %+v", cfg)
+	}
+
+	t.Setenv("MQTT_TOPICS", "")
+	if cfg := mqttConfigFromEnv(); !reflect.DeepEqual(cfg.topics, []string{"fleet/+/telemetry"}) {
+		t.Errorf("default topics = %q", cfg.topics)
+	}
+}
+
+func TestMQTTBridgeEnqueue(t *testing.T) {
+	defer func(d time.Duration) { mqttEnqueueTimeout = d }(mqttEnqueueTimeout)
+	mqttEnqueueTimeout = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &mqttBridge{ctx: ctx, msgs: make(chan mqttMessage, 1)}
+	b.enqueue(mqttMessage{vehicle: "B183"})
+	if len(b.msgs) != 1 || b.dropped.Load() != 0 {
+		t.Fatalf("%d buffered, %d dropped, want the message buffered", len(b.msgs), b.dropped.Load())
+	}
+
+	// A full buffer holds the handler back, up to the timeout
+	start := time.Now()
+	b.enqueue(mqttMessage{vehicle: "B208"})
+	if b.dropped.Load() != 1 || time.Since(start) < mqttEnqueueTimeout {
+		t.Errorf("full buffer: %d dropped after %v", b.dropped.Load(), time.Since(start))
+	}
+
+	// On shutdown waiting handlers return, the message is redelivered later
+	cancel()
+	mqttEnqueueTimeout = time.Hour
+	b.enqueue(mqttMessage{vehicle: "B208"})
+	if b.dropped.Load() != 1 || (<-b.msgs).vehicle != "B183" {
+		t.Error("shutdown dropped or buffered the message")
+	}
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"telemetry-dashboard/my_structs"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// At most this many findings are stored per mission; the total is still counted.
+const maxMissionFindings = 10000
+
+// Finding rules and actions as stored in quality_findings.
+const (
+	ruleRange     = "range"
+	ruleTime      = "time"
+	ruleMonotonic = "monotonic"
+	ruleDuplicate = "duplicate"
+	ruleGap       = "gap"
+
+	actionFlagged  = "flagged"
+	actionClamped  = "clamped"
+	actionRejected = "rejected"
+)
+
+type rangeCheck struct {
+	field int // index in the parsed row
+	col   string
+	rule  RangeRule
+}
+
+// ruleChecker applies validation rules to the parsed rows of one file.
+type ruleChecker struct {
+	rules     *ValidationRules
+	ranges    []rangeCheck
+	timeField int
+	notAfter  time.Time
+}
+
+func newRuleChecker(rules *ValidationRules, layout *columnLayout) *ruleChecker {
+	c := &ruleChecker{rules: rules, timeField: layout.timeField}
+	if rules == nil {
+		return c
+	}
+	for i, f := range layout.fields {
+		if rule, ok := rules.Columns[f.col]; ok {
+			c.ranges = append(c.ranges, rangeCheck{field: i, col: f.col, rule: rule})
+		}
+	}
+	if rules.Time != nil && rules.Time.MaxFuture != "" {
+		c.notAfter = time.Now().Add(rules.Time.maxFuture)
+	}
+	return c
+}
+
+// check validates a row in place, clamping values where the policy says so,
+// and records findings on the vehicle's mission. It returns the finding that
+// rejected the row, or nil when the row is kept.
+func (c *ruleChecker) check(line int, row []interface{}, st *missionStats) *my_structs.QualityFinding {
+	if c.rules == nil {
+		return nil
+	}
+	ts := row[c.timeField].(time.Time)
+
+	var findings []my_structs.QualityFinding
+	add := func(f my_structs.QualityFinding, policy rulePolicy) *my_structs.QualityFinding {
+		f.Line, f.TimeISO = line, &ts
+		switch policy {
+		case policyReject:
+			f.Action = actionRejected
+			findings = append(findings, f)
+			return &findings[len(findings)-1]
+		case policyClamp:
+			f.Action = actionClamped
+		default:
+			f.Action = actionFlagged
+		}
+		findings = append(findings, f)
+		return nil
+	}
+	rejected := func(f *my_structs.QualityFinding) *my_structs.QualityFinding {
+		st.rejectedRows++
+		st.addFindings(findings)
+		return f
+	}
+
+	if r := c.rules.Time; r != nil {
+		var detail string
+		if r.NotBefore != nil && ts.Before(*r.NotBefore) {
+			detail = fmt.Sprintf("timestamp before %s", r.NotBefore.Format(time.RFC3339))
+		} else if !c.notAfter.IsZero() && ts.After(c.notAfter) {
+			detail = fmt.Sprintf("timestamp more than %s in the future", r.MaxFuture)
+		}
+		if detail != "" {
+			if f := add(my_structs.QualityFinding{Rule: ruleTime, Column: "time_iso", Detail: detail}, r.Policy); f != nil {
+				return rejected(f)
+			}
+		}
+	}
+
+	for _, rc := range c.ranges {
+		v, ok := numericValue(row[rc.field])
+		if !ok {
+			continue
+		}
+		var limit float64
+		var detail string
+		switch {
+		case rc.rule.Min != nil && v < *rc.rule.Min:
+			limit, detail = *rc.rule.Min, fmt.Sprintf("below minimum %g", *rc.rule.Min)
+		case rc.rule.Max != nil && v > *rc.rule.Max:
+			limit, detail = *rc.rule.Max, fmt.Sprintf("above maximum %g", *rc.rule.Max)
+		default:
+			continue
+		}
+		value := v
+		f := my_structs.QualityFinding{Rule: ruleRange, Column: rc.col, Value: &value, Detail: detail}
+		if rf := add(f, rc.rule.Policy); rf != nil {
+			return rejected(rf)
+		}
+		if rc.rule.Policy == policyClamp {
+			row[rc.field] = clampedValue(row[rc.field], limit)
+		}
+	}
+
+	if st.seen {
+		switch {
+		case ts.Equal(st.prev) && c.rules.Duplicates != "":
+			f := my_structs.QualityFinding{Rule: ruleDuplicate, Column: "time_iso", Detail: "duplicate timestamp"}
+			if rf := add(f, c.rules.Duplicates); rf != nil {
+				return rejected(rf)
+			}
+		case ts.Before(st.prev) && c.rules.Monotonic != "":
+			detail := fmt.Sprintf("timestamp %s before previous sample", st.prev.Sub(ts))
+			f := my_structs.QualityFinding{Rule: ruleMonotonic, Column: "time_iso", Detail: detail}
+			if rf := add(f, c.rules.Monotonic); rf != nil {
+				return rejected(rf)
+			}
+		case c.rules.MaxGap != nil && ts.Sub(st.prev) > c.rules.MaxGap.max:
+			detail := fmt.Sprintf("gap of %s after previous sample", ts.Sub(st.prev))
+			add(my_structs.QualityFinding{Rule: ruleGap, Column: "time_iso", Detail: detail}, policyFlag)
+		}
+	}
+	if !st.seen || ts.After(st.prev) {
+		st.prev, st.seen = ts, true
+	}
+
+	if len(findings) > 0 {
+		st.flaggedRows++
+		st.addFindings(findings)
+	}
+	return nil
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// clampedValue returns limit with the type of the original value.
+func clampedValue(orig interface{}, limit float64) interface{} {
+	switch orig.(type) {
+	case int:
+		return int(math.Round(limit))
+	case int64:
+		return int64(math.Round(limit))
+	}
+	return limit
+}
+
+func (st *missionStats) addFindings(findings []my_structs.QualityFinding) {
+	st.findingCount += int64(len(findings))
+	for _, f := range findings {
+		if len(st.findings) >= maxMissionFindings {
+			return
+		}
+		st.findings = append(st.findings, f)
+	}
+}
+
+// qualityScore is the percentage of rows without findings, counting rows
+// rejected by a rule. It covers every row parsed from the file, including
+// rows that conflict=skip then did not write, so it rates the file rather
+// than the mission's row_count. Nil when the mission has no rows at all.
+func (st *missionStats) qualityScore() *float64 {
+	total := st.rows + st.rejectedRows
+	if total == 0 {
+		return nil
+	}
+	score := 100 * float64(st.rows-st.flaggedRows) / float64(total)
+	return &score
+}
+
+// saveFindings replaces the stored findings of a mission.
+func saveFindings(ctx context.Context, tx pgx.Tx, missionID int64, findings []my_structs.QualityFinding) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM quality_findings WHERE mission_id = $1", missionID); err != nil {
+		return fmt.Errorf("delete old findings: %w", err)
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"quality_findings"},
+		[]string{"mission_id", "line", "time_iso", "rule", "column_name", "action", "value", "detail"},
+		pgx.CopyFromSlice(len(findings), func(i int) ([]interface{}, error) {
+			f := findings[i]
+			return []interface{}{missionID, f.Line, f.TimeISO, f.Rule, f.Column, f.Action, f.Value, f.Detail}, nil
+		}))
+	if err != nil {
+		return fmt.Errorf("store findings: %w", err)
+	}
+	return nil
+}
+
+// MissionQuality is the data-quality report of a mission.
+type MissionQuality struct {
+	MissionID    int64                       `json:"mission_id"`
+	QualityScore *float64                    `json:"quality_score"`
+	FindingCount int64                       `json:"finding_count"`
+	RejectedRows int64                       `json:"rejected_rows"`
+	Findings     []my_structs.QualityFinding `json:"findings"`
+}
+
+// GetMissionQuality returns the quality score and stored findings of a
+// mission. Optional filters: rule, column, limit (max 1000), offset.
+func GetMissionQuality(c *gin.Context, pool *pgxpool.Pool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mission id"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		slog.Warn("invalid limit param, falling back to default", "limit", c.Query("limit"))
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out := MissionQuality{MissionID: id, Findings: []my_structs.QualityFinding{}}
+	err = pool.QueryRow(ctx, "SELECT quality_score, finding_count, rejected_rows FROM missions WHERE id = $1", id).
+		Scan(&out.QualityScore, &out.FindingCount, &out.RejectedRows)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "mission not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("mission quality query failed", "error", err, "id", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	where := []string{"mission_id = $1"}
+	args := []interface{}{id}
+	for _, p := range []string{"rule", "column"} {
+		if v := strings.TrimSpace(c.Query(p)); v != "" {
+			args = append(args, v)
+			col := p
+			if p == "column" {
+				col = "column_name"
+			}
+			where = append(where, fmt.Sprintf("%s = $%d", col, len(args)))
+		}
+	}
+	args = append(args, limit, offset)
+	query := fmt.Sprintf(`
+		SELECT mission_id, line, time_iso, rule, column_name, action, value, detail
+		FROM quality_findings
+		WHERE %s
+		ORDER BY line
+		LIMIT $%d OFFSET $%d
+	`, strings.Join(where, " AND "), len(args)-1, len(args))
+
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		slog.Error("findings query failed", "error", err, "id", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f my_structs.QualityFinding
+		if err := rows.Scan(&f.MissionID, &f.Line, &f.TimeISO, &f.Rule, &f.Column, &f.Action, &f.Value, &f.Detail); err != nil {
+			slog.Error("row scan failed inside findings", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
+			return
+		}
+		out.Findings = append(out.Findings, f)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("findings query failed", "error", err, "id", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var qualityTS = time.Date(2019, 6, 24, 3, 16, 0, 0, time.UTC)
+
+// checkRow runs a checker over a [time_iso, speed, door] row and returns the
+// rejecting rule, if any, and the rule/action of every recorded finding.
+func checkRow(t *testing.T, rules *ValidationRules, prev *time.Time, row []interface{}) (string, []string, *missionStats) {
+	t.Helper()
+	if rules != nil {
+		if err := rules.validate(); err != nil {
+			t.Fatalf("invalid rules: %v", err)
+		}
+	}
+	layout := &columnLayout{
+		fields:    []columnField{{col: "time_iso"}, {col: "odometry_vehicle_speed"}, {col: "status_door_is_open"}},
+		timeField: 0,
+	}
+	st := &missionStats{}
+	if prev != nil {
+		st.prev, st.seen = *prev, true
+	}
+
+	var rejectedBy string
+	if f := newRuleChecker(rules, layout).check(7, row, st); f != nil {
+		rejectedBy = f.Rule
+	}
+	var actions []string
+	for _, f := range st.findings {
+		if f.Line != 7 {
+			t.Errorf("finding %s on line %d, want 7", f.Rule, f.Line)
+		}
+		actions = append(actions, f.Rule+"/"+f.Action)
+	}
+	return rejectedBy, actions, st
+}
+
+func TestRuleCheckerRanges(t *testing.T) {
+	speed := func(policy rulePolicy) *ValidationRules {
+		return &ValidationRules{Columns: map[string]RangeRule{
+			"odometry_vehicle_speed": {Min: bound(0), Max: bound(30), Policy: policy},
+		}}
+	}
+
+	for _, tt := range []struct {
+		rules   *ValidationRules
+		in      []interface{}
+		want    []interface{}
+		actions []string
+	}{
+		{nil, []interface{}{qualityTS, 99.0, 5}, []interface{}{qualityTS, 99.0, 5}, nil},
+		{speed(policyReject), []interface{}{qualityTS, 12.5, 0}, []interface{}{qualityTS, 12.5, 0}, nil},
+		{speed(policyReject), []interface{}{qualityTS, nil, 0}, []interface{}{qualityTS, nil, 0}, nil},
+		{speed(policyFlag), []interface{}{qualityTS, 31.0, 0}, []interface{}{qualityTS, 31.0, 0}, []string{"range/flagged"}},
+		{speed(policyClamp), []interface{}{qualityTS, 31.0, 0}, []interface{}{qualityTS, 30.0, 0}, []string{"range/clamped"}},
+		{speed(policyClamp), []interface{}{qualityTS, -1.0, 0}, []interface{}{qualityTS, 0.0, 0}, []string{"range/clamped"}},
+		{
+			&ValidationRules{Columns: map[string]RangeRule{"status_door_is_open": {Min: bound(0), Max: bound(1), Policy: policyClamp}}},
+			[]interface{}{qualityTS, 10.0, 2}, []interface{}{qualityTS, 10.0, 1}, []string{"range/clamped"},
+		},
+	} {
+		rejectedBy, actions, st := checkRow(t, tt.rules, nil, tt.in)
+		if rejectedBy != "" {
+			t.Errorf("%v: rejected by %s", tt.want, rejectedBy)
+		}
+		if !reflect.DeepEqual(tt.in, tt.want) || !reflect.DeepEqual(actions, tt.actions) {
+			t.Errorf("row = %v with %v, want %v with %v", tt.in, actions, tt.want, tt.actions)
+		}
+		if wantFlagged := int64(len(tt.actions)); st.flaggedRows != wantFlagged {
+			t.Errorf("%v: flaggedRows = %d, want %d", tt.want, st.flaggedRows, wantFlagged)
+		}
+	}
+}
+
+func TestRuleCheckerReject(t *testing.T) {
+	later := qualityTS.Add(time.Second)
+	rules := map[string]*ValidationRules{
+		ruleRange: {Columns: map[string]RangeRule{"odometry_vehicle_speed": {Max: bound(30), Policy: policyReject}}},
+		ruleTime: {Time: &TimeRule{
+			NotBefore: func() *time.Time { t := qualityTS.Add(time.Hour); return &t }(),
+			Policy:    policyReject,
+		}},
+		ruleMonotonic: {Monotonic: policyReject},
+	}
+	for rule, r := range rules {
+		row := []interface{}{qualityTS, 31.0, 0}
+		rejectedBy, actions, st := checkRow(t, r, &later, row)
+		if rejectedBy != rule || !reflect.DeepEqual(actions, []string{rule + "/rejected"}) {
+			t.Errorf("%s: rejected by %q with %v", rule, rejectedBy, actions)
+		}
+		if st.rejectedRows != 1 || st.flaggedRows != 0 || row[1] != 31.0 {
+			t.Errorf("%s: rejected %d, flagged %d, row %v", rule, st.rejectedRows, st.flaggedRows, row)
+		}
+	}
+
+	future := []interface{}{time.Now().Add(48 * time.Hour), 10.0, 0}
+	if rejectedBy, _, _ := checkRow(t, &ValidationRules{Time: &TimeRule{MaxFuture: "24h", Policy: policyReject}}, nil, future); rejectedBy != ruleTime {
+		t.Errorf("timestamp 48h ahead rejected by %q, want time", rejectedBy)
+	}
+}
+
+func TestRuleCheckerSequence(t *testing.T) {
+	gap := &ValidationRules{MaxGap: &GapRule{Max: "60s", Policy: policyFlag}}
+	at := func(d time.Duration) *time.Time { t := qualityTS.Add(d); return &t }
+
+	_, actions, _ := checkRow(t, &ValidationRules{Duplicates: policyFlag}, at(0), []interface{}{qualityTS, 10.0, 0})
+	if !reflect.DeepEqual(actions, []string{"duplicate/flagged"}) {
+		t.Errorf("duplicate: %v", actions)
+	}
+	if _, actions, _ = checkRow(t, gap, at(-2*time.Minute), []interface{}{qualityTS, 10.0, 0}); !reflect.DeepEqual(actions, []string{"gap/flagged"}) {
+		t.Errorf("2m gap: %v", actions)
+	}
+	if _, actions, _ = checkRow(t, gap, at(-time.Second), []interface{}{qualityTS, 10.0, 0}); actions != nil {
+		t.Errorf("1s gap: %v", actions)
+	}
+
+	// A sample going backwards must not move the vehicle's latest timestamp
+	_, _, st := checkRow(t, &ValidationRules{Monotonic: policyFlag}, at(time.Minute), []interface{}{qualityTS, 10.0, 0})
+	if !st.prev.Equal(*at(time.Minute)) {
+		t.Errorf("prev = %v after a backwards sample", st.prev)
+	}
+}
+
+func TestQualityScore(t *testing.T) {
+	if s := (&missionStats{}).qualityScore(); s != nil {
+		t.Errorf("empty mission score = %v, want nil", *s)
+	}
+	st := &missionStats{rows: 6, flaggedRows: 1, rejectedRows: 2}
+	if s := st.qualityScore(); s == nil || *s != 62.5 {
+		t.Errorf("score = %v, want 62.5", s)
+	}
+}
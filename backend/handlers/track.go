@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Bounds of the tolerance parameter of /track, in meters.
+const (
+	defaultTrackTolerance = 5.0
+	maxTrackTolerance     = 1000.0
+)
+
+// TRACK_MAX_POINTS caps the GNSS samples a /track request may load.
+var trackMaxPoints = envInt64("TRACK_MAX_POINTS", 100000)
+
+// trackPoint is a GNSS sample with the selected metric values.
+type trackPoint struct {
+	t        time.Time
+	lon, lat float64
+	alt      *float64
+	values   []*float64
+	x, y     float64 // local meters, for simplification
+}
+
+// GetTrack returns the GNSS track of one vehicle as a GeoJSON
+// FeatureCollection. The track is simplified with Douglas-Peucker to the
+// tolerance in meters. metrics adds per-point values to the LineString;
+// color_by splits the track into segments carrying the metric value, for
+// data-driven line colors.
+func GetTrack(c *gin.Context, pool *pgxpool.Pool) {
+	filters, valid := parseQueryFilters(c)
+	if !valid || !filters.single() {
+		slog.Warn("invalid track request params", "vehicle", c.Query("vehicle_id"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters: one vehicle_id, start and end are required"})
+		return
+	}
+	vehicle := filters.VehicleIDs[0]
+
+	tolerance := defaultTrackTolerance
+	if raw := c.Query("tolerance"); raw != "" {
+		var err error
+		if tolerance, err = strconv.ParseFloat(raw, 64); err != nil || tolerance < 0 || tolerance > maxTrackTolerance {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("tolerance must be between 0 and %g meters", maxTrackTolerance)})
+			return
+		}
+	}
+
+	var metrics []*Metric
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(c.Query("metrics"), ",") {
+		if id = strings.TrimSpace(id); id == "" || seen[id] {
+			continue
+		}
+		m, err := lookupMetric(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		seen[id] = true
+		metrics = append(metrics, m)
+	}
+	colorIndex := -1
+	if id := c.Query("color_by"); id != "" {
+		m, err := lookupMetric(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !seen[id] {
+			metrics = append(metrics, m)
+		}
+		for i := range metrics {
+			if metrics[i].ID == id {
+				colorIndex = i
+			}
+		}
+	}
+
+	slog.Info("handling track request", "vehicle", vehicle, "start", filters.Start, "end", filters.End,
+		"tolerance", tolerance, "metrics", len(metrics), "color_by", c.Query("color_by"))
+
+	cols := []string{"time_iso", "gnss_longitude", "gnss_latitude", "gnss_altitude"}
+	for _, m := range metrics {
+		cols = append(cols, m.Column+"::float8")
+	}
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM telemetry
+		WHERE vehicle_id = $1
+		  AND time_iso >= $2::timestamptz
+		  AND time_iso <= $3::timestamptz
+		  AND gnss_latitude IS NOT NULL
+		  AND gnss_longitude IS NOT NULL
+		ORDER BY time_iso
+		LIMIT $4
+	`, strings.Join(cols, ", "))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := pool.Query(ctx, query, vehicle, filters.Start, filters.End, trackMaxPoints+1)
+	if err != nil {
+		slog.Error("track query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	defer rows.Close()
+
+	var points []trackPoint
+	for rows.Next() {
+		if int64(len(points)) == trackMaxPoints {
+			slog.Warn("track exceeds point budget", "vehicle", vehicle, "max", trackMaxPoints)
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("track has more than %d points: use a shorter range", trackMaxPoints)})
+			return
+		}
+		p := trackPoint{values: make([]*float64, len(metrics))}
+		dest := []interface{}{&p.t, &p.lon, &p.lat, &p.alt}
+		for i := range p.values {
+			dest = append(dest, &p.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			slog.Error("row scan failed inside track", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
+			return
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("track query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	for i := range points {
+		points[i].x, points[i].y = localMeters(points[i].lat, points[i].lon, points[0].lat, points[0].lon)
+	}
+	kept := douglasPeucker(points, tolerance)
+	simplified := make([]trackPoint, len(kept))
+	for i, k := range kept {
+		simplified[i] = points[k]
+	}
+
+	fc := newFeatureCollection()
+	fc.Properties = map[string]interface{}{
+		"vehicle_id": vehicle,
+		"start":      filters.Start,
+		"end":        filters.End,
+		"tolerance":  tolerance,
+		"raw_points": len(points),
+		"points":     len(simplified),
+	}
+
+	if colorIndex >= 0 {
+		fc.Features = trackSegments(points, kept, metrics, colorIndex)
+		lo, hi := segmentRange(fc.Features)
+		fc.Properties["color_by"] = map[string]interface{}{"metric": metrics[colorIndex].ID, "unit": metrics[colorIndex].Unit, "min": lo, "max": hi}
+	} else if len(simplified) > 0 {
+		fc.Features = append(fc.Features, trackLine(vehicle, simplified, metrics))
+	}
+
+	slog.Info("track computed", "vehicle", vehicle, "raw_points", len(points), "points", len(simplified))
+	c.JSON(http.StatusOK, fc)
+}
+
+func trackCoordinates(p trackPoint) []float64 {
+	if p.alt != nil {
+		return []float64{p.lon, p.lat, *p.alt}
+	}
+	return []float64{p.lon, p.lat}
+}
+
+// trackLine is the whole track as one LineString. Timestamps and metric
+// values are arrays aligned with the coordinates.
+func trackLine(vehicle string, points []trackPoint, metrics []*Metric) GeoJSONFeature {
+	coords := make([][]float64, len(points))
+	times := make([]string, len(points))
+	values := make(map[string][]*float64, len(metrics))
+	for i, p := range points {
+		coords[i] = trackCoordinates(p)
+		times[i] = p.t.Format(time.RFC3339)
+		for j, m := range metrics {
+			values[m.ID] = append(values[m.ID], p.values[j])
+		}
+	}
+	props := map[string]interface{}{"vehicle_id": vehicle, "times": times}
+	if len(metrics) > 0 {
+		props["metrics"] = values
+	}
+	// A single point is not a valid LineString
+	if len(coords) == 1 {
+		return newFeature("Point", coords[0], props)
+	}
+	return newFeature("LineString", coords, props)
+}
+
+// trackSegments splits the track into one LineString per pair of consecutive
+// kept points. Each segment aggregates every original sample of its span,
+// including those dropped by the simplification: "value" and "value_max" are
+// the mean and maximum of the color metric, the other metrics are means.
+func trackSegments(points []trackPoint, kept []int, metrics []*Metric, colorIndex int) []GeoJSONFeature {
+	features := []GeoJSONFeature{}
+	for i := 1; i < len(kept); i++ {
+		a, b := points[kept[i-1]], points[kept[i]]
+		span := points[kept[i-1] : kept[i]+1]
+		mean, peak := spanStats(span, colorIndex)
+		props := map[string]interface{}{
+			"start":     a.t.Format(time.RFC3339),
+			"end":       b.t.Format(time.RFC3339),
+			"value":     mean,
+			"value_max": peak,
+		}
+		for j, m := range metrics {
+			props[m.ID], _ = spanStats(span, j)
+		}
+		features = append(features, newFeature("LineString", [][]float64{trackCoordinates(a), trackCoordinates(b)}, props))
+	}
+	return features
+}
+
+// spanStats returns the mean and maximum of metric index over points,
+// nil when no point has a value.
+func spanStats(points []trackPoint, index int) (mean, peak *float64) {
+	var sum float64
+	var n int
+	for _, p := range points {
+		v := p.values[index]
+		if v == nil {
+			continue
+		}
+		sum += *v
+		n++
+		if peak == nil || *v > *peak {
+			peak = v
+		}
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	m := sum / float64(n)
+	return &m, peak
+}
+
+// segmentRange returns the range of the segment values, for the color scale.
+func segmentRange(features []GeoJSONFeature) (lo, hi *float64) {
+	for _, f := range features {
+		v, _ := f.Properties["value"].(*float64)
+		if v == nil {
+			continue
+		}
+		if lo == nil || *v < *lo {
+			lo = v
+		}
+		if hi == nil || *v > *hi {
+			hi = v
+		}
+	}
+	return lo, hi
+}
+
+// douglasPeucker returns the indices of the points needed to stay within
+// tolerance meters of the original track, in order. Iterative, so long
+// tracks cannot exhaust the stack.
+func douglasPeucker(points []trackPoint, tolerance float64) []int {
+	if tolerance <= 0 || len(points) <= 2 {
+		out := make([]int, len(points))
+		for i := range out {
+			out[i] = i
+		}
+		return out
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDist := -1, tolerance
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(points[i], points[first], points[last]); d > maxDist {
+				farthest, maxDist = i, d
+			}
+		}
+		if farthest >= 0 {
+			keep[farthest] = true
+			stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+		}
+	}
+
+	var out []int
+	for i, k := range keep {
+		if k {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// segmentDistance is the distance in meters from p to the segment a-b.
+func segmentDistance(p, a, b trackPoint) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p.x-a.x, p.y-a.y)
+	}
+	t := ((p.x-a.x)*dx + (p.y-a.y)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p.x-(a.x+t*dx), p.y-(a.y+t*dy))
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// xyTrack returns track points at the given local coordinates in meters.
+func xyTrack(coords ...[2]float64) []trackPoint {
+	t0 := time.Date(2019, 6, 24, 3, 16, 0, 0, time.UTC)
+	out := make([]trackPoint, len(coords))
+	for i, c := range coords {
+		out[i] = trackPoint{t: t0.Add(time.Duration(i) * time.Second), x: c[0], y: c[1]}
+	}
+	return out
+}
+
+func TestDouglasPeucker(t *testing.T) {
+	straight := xyTrack([2]float64{0, 0}, [2]float64{10, 0.5}, [2]float64{20, -0.5}, [2]float64{30, 0})
+	corner := xyTrack([2]float64{0, 0}, [2]float64{50, 0}, [2]float64{100, 0}, [2]float64{100, 50}, [2]float64{100, 100})
+	// Back at the start, the chord of the first and last point is a single
+	// position and distances are measured to it
+	loop := xyTrack([2]float64{0, 0}, [2]float64{40, 0}, [2]float64{40, 40}, [2]float64{0, 40}, [2]float64{0, 0})
+
+	for _, tt := range []struct {
+		points    []trackPoint
+		tolerance float64
+		want      string
+	}{
+		{straight, 1, "[0 3]"},
+		{straight, 0.1, "[0 1 2 3]"},
+		{straight, 0, "[0 1 2 3]"},
+		{corner, 5, "[0 2 4]"},
+		{loop, 5, "[0 1 2 3 4]"},
+		{straight[:2], 5, "[0 1]"},
+		{nil, 5, "[]"},
+	} {
+		if got := fmt.Sprint(douglasPeucker(tt.points, tt.tolerance)); got != tt.want {
+			t.Errorf("%d points within %g m: kept %s, want %s", len(tt.points), tt.tolerance, got, tt.want)
+		}
+	}
+}
+
+func TestTrackSegments(t *testing.T) {
+	v := func(f float64) *float64 { return &f }
+	points := xyTrack([2]float64{0, 0}, [2]float64{1, 0}, [2]float64{2, 0}, [2]float64{3, 0})
+	points[0].values = []*float64{v(10), nil}
+	points[1].values = []*float64{v(40), v(1)}
+	points[2].values = []*float64{v(10), nil}
+	points[3].values = []*float64{nil, nil}
+
+	speed, _ := lookupMetric("speed")
+	door, _ := lookupMetric("door_open")
+	// Point 1 was dropped by the simplification but still counts
+	segments := trackSegments(points, []int{0, 2, 3}, []*Metric{speed, door}, 0)
+	if len(segments) != 2 {
+		t.Fatalf("%d segments, want 2", len(segments))
+	}
+
+	first := segments[0].Properties
+	if *first["value"].(*float64) != 20 || *first["value_max"].(*float64) != 40 || *first["door_open"].(*float64) != 1 {
+		t.Errorf("first segment = %v", first)
+	}
+	if first["start"] != "2019-06-24T03:16:00Z" || first["end"] != "2019-06-24T03:16:02Z" {
+		t.Errorf("first segment spans %v – %v", first["start"], first["end"])
+	}
+	if second := segments[1].Properties; *second["value"].(*float64) != 10 || second["door_open"].(*float64) != nil {
+		t.Errorf("second segment = %v", second)
+	}
+
+	lo, hi := segmentRange(segments)
+	if *lo != 10 || *hi != 20 {
+		t.Errorf("color range = %v – %v, want 10 – 20", *lo, *hi)
+	}
+	if lo, hi := segmentRange(nil); lo != nil || hi != nil {
+		t.Error("range without segments must be nil")
+	}
+}
+
+func TestTrackLine(t *testing.T) {
+	alt := 441.2
+	points := []trackPoint{
+		{t: time.Date(2019, 6, 24, 3, 16, 0, 0, time.UTC), lon: 8.5417, lat: 47.3769, alt: &alt},
+		{t: time.Date(2019, 6, 24, 3, 16, 1, 0, time.UTC), lon: 8.5418, lat: 47.3770},
+	}
+	line := trackLine("B183", points, nil)
+	coords := line.Geometry.Coordinates.([][]float64)
+	if line.Geometry.Type != "LineString" || len(coords[0]) != 3 || len(coords[1]) != 2 {
+		t.Errorf("line = %+v", line.Geometry)
+	}
+	if _, ok := line.Properties["metrics"]; ok || line.Properties["vehicle_id"] != "B183" {
+		t.Errorf("properties = %v", line.Properties)
+	}
+
+	if point := trackLine("B183", points[:1], nil); point.Geometry.Type != "Point" {
+		t.Errorf("single sample is a %s", point.Geometry.Type)
+	}
+}
+
+func TestLocalMeters(t *testing.T) {
+	x, y := localMeters(48.3769, 8.5417, 47.3769, 8.5417)
+	if x != 0 || math.Abs(y-111195) > 1 {
+		t.Errorf("one degree north = %g, %g m", x, y)
+	}
+	// Meridians converge: a degree east is shorter away from the equator
+	x, _ = localMeters(47.3769, 9.5417, 47.3769, 8.5417)
+	if math.Abs(x-111195*math.Cos(47.3769*math.Pi/180)) > 1 {
+		t.Errorf("one degree east = %g m", x)
+	}
+}
@@ -5,37 +5,79 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Bounds of the max_points parameter of /trend. Without max_points, series
+// are not downsampled and bucket=auto aims at defaultTrendPoints buckets.
+const (
+	defaultTrendPoints = 2000
+	maxTrendPoints     = 20000
+)
+
+// TREND_MAX_ROWS caps the rows a /trend query may load, over all vehicles.
+var trendMaxRows = envInt64("TREND_MAX_ROWS", 500000)
+
 func GetTrend(c *gin.Context, pool *pgxpool.Pool) {
 	filters, valid := parseQueryFilters(c)
 	if !valid {
-		slog.Warn("invalid trend request params", "vehicle", c.Query("vehicle_id"))
+		slog.Warn("invalid trend request params", "vehicle", c.Query("vehicle_id"), "fleet", c.Query("fleet"))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parameters"})
 		return
 	}
 
 	metric := c.DefaultQuery("metric", "speed")
-	if err := validateMetric(metric); err != nil {
+	m, err := lookupMetric(metric)
+	if err != nil {
 		slog.Warn("invalid trend params", "metric", metric, "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "metric is not valid"})
 		return
 	}
 
+	maxPoints := 0
+	if raw := c.Query("max_points"); raw != "" {
+		if maxPoints, err = strconv.Atoi(raw); err != nil || maxPoints < 3 || maxPoints > maxTrendPoints {
+			slog.Warn("invalid trend params", "max_points", raw)
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("max_points must be between 3 and %d", maxTrendPoints)})
+			return
+		}
+	}
+
+	spec, err := parseTrendSpec(c, m, filters, maxPoints)
+	if err != nil {
+		slog.Warn("invalid trend params", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	bucket := "raw"
+	if spec.bucket != nil {
+		bucket = spec.bucket.name
+	}
+
 	slog.Info("handling trend request",
-		"metric", metric, "vehicle", filters.VehicleID, "start", filters.Start, "end", filters.End)
+		"metric", metric, "vehicles", filters.label(), "start", filters.Start, "end", filters.End,
+		"agg", spec.agg, "bucket", bucket, "max_points", maxPoints)
+
+	// Bucket counts are known up front for a list of vehicles; everything
+	// else is stopped by the LIMIT below
+	if rows := spec.maxRows(filters); rows > trendMaxRows {
+		slog.Warn("trend request exceeds row budget", "rows", rows, "max", trendMaxRows)
+		c.JSON(http.StatusBadRequest, gin.H{"error": trendBudgetError})
+		return
+	}
 
-	queryStr := buildTrendQuery(metric, filters)
+	queryStr, args := buildTrendQuery(m, filters, spec)
+	queryStr += fmt.Sprintf("LIMIT %d\n", trendMaxRows+1)
 	slog.Debug("constructed trend query", "sql", queryStr)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	rows, err := pool.Query(ctx, queryStr, filters.VehicleID, filters.Start, filters.End)
+	rows, err := pool.Query(ctx, queryStr, args...)
 	if err != nil {
 		slog.Error("trend query failed", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
@@ -43,58 +85,296 @@ func GetTrend(c *gin.Context, pool *pgxpool.Pool) {
 	}
 	defer rows.Close()
 
-	type Point struct {
-		Timestamp string  `json:"timestamp"`
-		Value     float64 `json:"value"`
+	// One series per requested vehicle, in request order
+	var vehicles []string
+	samples := make(map[string][]trendSample)
+	for _, id := range filters.VehicleIDs {
+		vehicles = append(vehicles, id)
+		samples[id] = nil
 	}
-	var result []Point
+	count := 0
 	for rows.Next() {
+		if count++; int64(count) > trendMaxRows {
+			slog.Warn("trend query exceeds row budget", "max", trendMaxRows)
+			c.JSON(http.StatusBadRequest, gin.H{"error": trendBudgetError})
+			return
+		}
+		var vehicle string
 		var ts time.Time
 		var v *float64
-		if err := rows.Scan(&ts, &v); err != nil {
+		if err := rows.Scan(&vehicle, &ts, &v); err != nil {
 			slog.Error("row scan failed inside trend", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
 			return
 		}
-		if v != nil {
-			result = append(result, Point{Timestamp: ts.Format(time.RFC3339), Value: *v})
+		if v == nil {
+			continue
 		}
+		if _, ok := samples[vehicle]; !ok {
+			vehicles = append(vehicles, vehicle)
+		}
+		samples[vehicle] = append(samples[vehicle], trendSample{t: ts, v: *v})
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("trend query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	series := []TrendSeries{}
+	returned := 0
+	for _, vehicle := range vehicles {
+		points := []TrendPoint{}
+		// maxPoints 0 (no max_points) keeps every point
+		for _, p := range lttb(samples[vehicle], maxPoints) {
+			points = append(points, TrendPoint{Timestamp: p.t.Format(time.RFC3339), Value: p.v})
+		}
+		returned += len(points)
+		series = append(series, TrendSeries{VehicleID: vehicle, Points: points})
 	}
 
-	slog.Info("trend query returned rows", "metric", metric, "count", len(result))
-	c.JSON(http.StatusOK, result)
+	slog.Info("trend query returned rows", "metric", metric, "series", len(series), "count", count, "returned", returned)
+	// A single vehicle keeps the plain list of points
+	if filters.single() {
+		c.JSON(http.StatusOK, series[0].Points)
+		return
+	}
+	c.JSON(http.StatusOK, series)
 }
 
-func buildTrendQuery(metric string, filters *QueryFilters) string {
-	col := allowedMetrics[metric]
-	duration := getDuration(filters.Start, filters.End)
+type TrendPoint struct {
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// TrendSeries is the trend of one vehicle when several are requested.
+type TrendSeries struct {
+	VehicleID string       `json:"vehicle_id"`
+	Points    []TrendPoint `json:"points"`
+}
+
+// trendAggregations maps the agg parameter to its SQL over raw samples.
+var trendAggregations = map[string]string{
+	"avg":   "AVG(%s)",
+	"min":   "MIN(%s)",
+	"max":   "MAX(%s)",
+	"sum":   "SUM(%s)",
+	"count": "COUNT(%s)",
+	"p50":   "percentile_cont(0.5) WITHIN GROUP (ORDER BY %s)",
+	"p95":   "percentile_cont(0.95) WITHIN GROUP (ORDER BY %s)",
+	"last":  "last(%s, time_iso)",
+}
+
+// Aggregations that stay meaningful for metrics that are not Aggregatable.
+var positionAggregations = map[string]bool{"min": true, "max": true, "count": true, "last": true}
+
+// tierAggregations roll the <metric>_avg/_min/_max/_count columns of an
+// aggregate tier up into a coarser bucket; other aggregations need raw data.
+var tierAggregations = map[string]string{
+	"avg":   "SUM(%[1]s_avg * %[1]s_count) / NULLIF(SUM(%[1]s_count), 0)",
+	"min":   "MIN(%[1]s_min)",
+	"max":   "MAX(%[1]s_max)",
+	"sum":   "SUM(%[1]s_avg * %[1]s_count)",
+	"count": "SUM(%[1]s_count)",
+}
+
+// aggregateTier is a hierarchical continuous aggregate over every metric
+// (see db/seed.sql).
+type aggregateTier struct {
+	table    string
+	width    time.Duration
+	interval string // time_bucket width of the tier
+}
 
-	var baseQuery, timeCol string
-	if duration > 1*time.Hour {
-		// Use aggregated tables for better performance
-		if table, exists := aggregatedTables[metric]; exists {
-			slog.Debug("long time interval selected, querying aggregated table", "interval", duration)
-			aggCol := aggregatedColumns[metric]
-			baseQuery = fmt.Sprintf(`SELECT bucket AS time_iso, %s AS value FROM %s`, aggCol, table)
-			timeCol = "bucket"
-		} else {
-			// Fallback to raw telemetry
-			baseQuery = fmt.Sprintf(`SELECT time_iso, %s AS value FROM telemetry`, col)
-			timeCol = "time_iso"
+// aggregateTiers are ordered from finest to coarsest.
+var aggregateTiers = []aggregateTier{
+	{"telemetry_1m", time.Minute, "1 minute"},
+	{"telemetry_1h", time.Hour, "1 hour"},
+	{"telemetry_1d", 24 * time.Hour, "1 day"},
+}
+
+// tierSpan returns the tier buckets that lie entirely within [start, end],
+// as [first, last). Samples before first and from last on are read from raw
+// telemetry. first == last when no bucket fits.
+func tierSpan(tier *aggregateTier, start, end time.Time) (first, last time.Time) {
+	first = start.Truncate(tier.width)
+	if first.Before(start) {
+		first = first.Add(tier.width)
+	}
+	// The bucket holding end is only partly requested
+	last = end.Truncate(tier.width)
+	if !last.After(first) {
+		return start, start
+	}
+	return first, last
+}
+
+// tierFor returns the coarsest tier whose buckets add up to the requested
+// bucket, or nil when the aggregation needs raw data.
+func tierFor(spec trendSpec) *aggregateTier {
+	if spec.bucket == nil {
+		return nil
+	}
+	if _, ok := tierAggregations[spec.agg]; !ok {
+		return nil
+	}
+	var tier *aggregateTier
+	for i := range aggregateTiers {
+		if spec.bucket.width%aggregateTiers[i].width == 0 {
+			tier = &aggregateTiers[i]
+		}
+	}
+	return tier
+}
+
+// trendBucket is a supported bucket width of /trend.
+type trendBucket struct {
+	name     string
+	width    time.Duration
+	interval string // time_bucket width
+}
+
+// trendBuckets are ordered from finest to coarsest.
+var trendBuckets = []trendBucket{
+	{"10s", 10 * time.Second, "10 seconds"},
+	{"1m", time.Minute, "1 minute"},
+	{"15m", 15 * time.Minute, "15 minutes"},
+	{"1h", time.Hour, "1 hour"},
+	{"1d", 24 * time.Hour, "1 day"},
+}
+
+// trendSpec is how a trend is computed: raw samples when bucket is nil,
+// otherwise agg per bucket.
+type trendSpec struct {
+	agg    string
+	bucket *trendBucket
+}
+
+const trendBudgetError = "too many rows for one trend: use a coarser bucket, a shorter range or fewer vehicles"
+
+// maxRows returns the most rows a bucketed trend over a list of vehicles
+// can return, or 0 when that is not known in advance.
+func (s trendSpec) maxRows(filters *QueryFilters) int64 {
+	if s.bucket == nil || filters.VehicleIDs == nil {
+		return 0
+	}
+	// Count the buckets touched, the first and last ones may be partial
+	w := s.bucket.width
+	buckets := int64(filters.End.Truncate(w).Sub(filters.Start.Truncate(w))/w) + 1
+	return buckets * int64(len(filters.VehicleIDs))
+}
+
+// parseTrendSpec reads the agg and bucket parameters. bucket=auto (the
+// default) returns raw samples for ranges up to one hour when no agg is
+// given, and otherwise the finest bucket that yields at most maxPoints
+// buckets (defaultTrendPoints when maxPoints is 0).
+func parseTrendSpec(c *gin.Context, m *Metric, filters *QueryFilters, maxPoints int) (trendSpec, error) {
+	if maxPoints == 0 {
+		maxPoints = defaultTrendPoints
+	}
+	agg := c.Query("agg")
+	spec := trendSpec{agg: agg}
+	if spec.agg == "" {
+		spec.agg = "avg"
+		if !m.Aggregatable {
+			spec.agg = "last"
 		}
-	} else {
-		// Raw telemetry for short time ranges
-		baseQuery = fmt.Sprintf(`SELECT time_iso, %s AS value FROM telemetry`, col)
-		timeCol = "time_iso"
+	}
+	if _, ok := trendAggregations[spec.agg]; !ok {
+		return spec, fmt.Errorf("invalid agg %q (avg, min, max, sum, count, p50, p95 or last)", spec.agg)
+	}
+	if !m.Aggregatable && !positionAggregations[spec.agg] {
+		return spec, fmt.Errorf("metric %s does not support agg=%s", m.ID, spec.agg)
 	}
 
-	query := fmt.Sprintf(`
+	duration := getDuration(filters.Start, filters.End)
+	switch name := c.DefaultQuery("bucket", "auto"); name {
+	case "raw":
+		if agg != "" {
+			return spec, fmt.Errorf("agg cannot be combined with bucket=raw")
+		}
+	case "auto":
+		if agg == "" && duration <= time.Hour {
+			break
+		}
+		spec.bucket = &trendBuckets[len(trendBuckets)-1]
+		for i := range trendBuckets {
+			if duration/trendBuckets[i].width <= time.Duration(maxPoints) {
+				spec.bucket = &trendBuckets[i]
+				break
+			}
+		}
+	default:
+		for i := range trendBuckets {
+			if trendBuckets[i].name == name {
+				spec.bucket = &trendBuckets[i]
+			}
+		}
+		if spec.bucket == nil {
+			return spec, fmt.Errorf("invalid bucket %q (raw, 10s, 1m, 15m, 1h, 1d or auto)", name)
+		}
+	}
+	return spec, nil
+}
+
+// buildTrendQuery selects (vehicle_id, time, value) rows for $1 vehicles
+// between $2 and $3 and returns the query with its arguments. Buckets are
+// rolled up from the coarsest aggregate tier that fits the bucket, and
+// otherwise computed with time_bucket over raw telemetry. Tier buckets that
+// are only partly within the range are recomputed from raw telemetry, so
+// that the result does not depend on the tier.
+func buildTrendQuery(m *Metric, filters *QueryFilters, spec trendSpec) (string, []interface{}) {
+	args := []interface{}{filters.vehicleArg(), filters.Start, filters.End}
+	tier := tierFor(spec)
+	if tier == nil {
+		selectExpr, groupBy := fmt.Sprintf("time_iso, %s::float8", m.Column), ""
+		if spec.bucket != nil {
+			selectExpr = fmt.Sprintf("time_bucket('%s', time_iso), (%s)::float8",
+				spec.bucket.interval, fmt.Sprintf(trendAggregations[spec.agg], m.Column))
+			groupBy = "GROUP BY 1, 2"
+		}
+		return fmt.Sprintf(`
+		SELECT vehicle_id, %s AS value
+		FROM telemetry
+		WHERE %s
+		  AND time_iso >= $2::timestamptz
+		  AND time_iso <= $3::timestamptz
 		%s
-		WHERE vehicle_id = $1
-		  AND %s >= $2::timestamptz
-		  AND %s <= $3::timestamptz
-		ORDER BY %s
-	`, baseQuery, timeCol, timeCol, timeCol)
+		ORDER BY 1, 2
+	`, selectExpr, vehicleCondition, groupBy), args
+	}
+
+	first, last := tierSpan(tier, filters.Start, filters.End)
+	slog.Debug("querying aggregate tier", "table", tier.table, "bucket", spec.bucket.name,
+		"tier_from", first, "tier_to", last)
+	args = append(args, first, last)
+
+	// Partial tier rows of the edges, with the same columns as the tier
+	raw := fmt.Sprintf(`
+			SELECT vehicle_id, time_bucket('%[1]s', time_iso) AS bucket,
+			       AVG(%[2]s)::float8 AS %[3]s_avg, MIN(%[2]s)::float8 AS %[3]s_min,
+			       MAX(%[2]s)::float8 AS %[3]s_max, COUNT(%[2]s)::bigint AS %[3]s_count
+			FROM telemetry
+			WHERE %[4]s
+			  AND %%s
+			GROUP BY 1, 2`, tier.interval, m.Column, m.ID, vehicleCondition)
 
-	return query
+	return fmt.Sprintf(`
+		WITH parts AS (
+			SELECT vehicle_id, bucket, %[1]s_avg, %[1]s_min, %[1]s_max, %[1]s_count
+			FROM %[2]s
+			WHERE %[3]s
+			  AND bucket >= $4::timestamptz
+			  AND bucket < $5::timestamptz
+			UNION ALL%[4]s
+			UNION ALL%[5]s
+		)
+		SELECT vehicle_id, time_bucket('%[6]s', bucket), (%[7]s)::float8 AS value
+		FROM parts
+		GROUP BY 1, 2
+		ORDER BY 1, 2
+	`, m.ID, tier.table, vehicleCondition,
+		fmt.Sprintf(raw, "time_iso >= $2::timestamptz AND time_iso < $4::timestamptz"),
+		fmt.Sprintf(raw, "time_iso >= $5::timestamptz AND time_iso <= $3::timestamptz"),
+		spec.bucket.interval, fmt.Sprintf(tierAggregations[spec.agg], m.ID)), args
 }
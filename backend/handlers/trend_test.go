@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var trendStart = time.Date(2019, 6, 24, 0, 0, 0, 0, time.UTC)
+
+// describeSpec parses a trend query and describes the result as "agg@bucket",
+// "agg@raw" or "error".
+func describeSpec(t *testing.T, metric, query string, d time.Duration, maxPoints int) string {
+	t.Helper()
+	m, err := lookupMetric(metric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := &QueryFilters{VehicleIDs: []string{"B183"}, Start: trendStart, End: trendStart.Add(d)}
+	spec, err := parseTrendSpec(queryContext(query), m, filters, maxPoints)
+	if err != nil {
+		return "error"
+	}
+	if spec.bucket == nil {
+		return spec.agg + "@raw"
+	}
+	return spec.agg + "@" + spec.bucket.name
+}
+
+func TestParseTrendSpec(t *testing.T) {
+	const day = 24 * time.Hour
+	for _, tt := range []struct {
+		metric, query string
+		d             time.Duration
+		maxPoints     int
+		want          string
+	}{
+		// bucket=auto
+		{"speed", "", time.Hour, defaultTrendPoints, "avg@raw"},
+		{"speed", "", 2 * time.Hour, defaultTrendPoints, "avg@10s"},
+		{"speed", "", day, defaultTrendPoints, "avg@1m"},
+		{"speed", "", 30 * day, defaultTrendPoints, "avg@1h"},
+		{"speed", "", 3650 * day, defaultTrendPoints, "avg@1d"},
+		{"speed", "", 2 * time.Hour, 100, "avg@15m"},
+		{"speed", "", day, 0, "avg@1m"}, // no max_points
+		{"speed", "agg=max", 10 * time.Minute, defaultTrendPoints, "max@10s"},
+		// explicit buckets
+		{"speed", "agg=p95&bucket=1h", day, defaultTrendPoints, "p95@1h"},
+		{"speed", "bucket=raw", day, defaultTrendPoints, "avg@raw"},
+		{"speed", "agg=max&bucket=raw", day, defaultTrendPoints, "error"},
+		{"speed", "bucket=5m", day, defaultTrendPoints, "error"},
+		{"speed", "agg=median", day, defaultTrendPoints, "error"},
+		// metrics that cannot be averaged
+		{"course", "", time.Hour, defaultTrendPoints, "last@raw"},
+		{"course", "agg=max&bucket=1m", time.Hour, defaultTrendPoints, "max@1m"},
+		{"course", "agg=avg", time.Hour, defaultTrendPoints, "error"},
+	} {
+		if got := describeSpec(t, tt.metric, tt.query, tt.d, tt.maxPoints); got != tt.want {
+			t.Errorf("%s?%s over %v: %s, want %s", tt.metric, tt.query, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestTierFor(t *testing.T) {
+	// "agg bucket" → tier table, "" for raw telemetry
+	for in, want := range map[string]string{
+		"avg raw":  "",
+		"avg 10s":  "",
+		"avg 1m":   "telemetry_1m",
+		"avg 15m":  "telemetry_1m",
+		"sum 1h":   "telemetry_1h",
+		"count 1h": "telemetry_1h",
+		"max 1d":   "telemetry_1d",
+		"min 1d":   "telemetry_1d",
+		"p95 1h":   "",
+		"p50 1d":   "",
+		"last 1h":  "",
+	} {
+		agg, bucket, _ := strings.Cut(in, " ")
+		spec := trendSpec{agg: agg}
+		for i := range trendBuckets {
+			if trendBuckets[i].name == bucket {
+				spec.bucket = &trendBuckets[i]
+			}
+		}
+		var got string
+		if tier := tierFor(spec); tier != nil {
+			got = tier.table
+		}
+		if got != want {
+			t.Errorf("tierFor(%s) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestAggregateTierColumns checks that db/seed.sql defines the rollup
+// columns of every metric in every tier.
+func TestAggregateTierColumns(t *testing.T) {
+	seed, err := os.ReadFile("../../db/seed.sql")
+	if err != nil {
+		t.Skipf("seed not available: %v", err)
+	}
+	for _, tier := range aggregateTiers {
+		_, view, ok := strings.Cut(string(seed), "CREATE MATERIALIZED VIEW IF NOT EXISTS "+tier.table+"\n")
+		if !ok {
+			t.Errorf("%s is not defined", tier.table)
+			continue
+		}
+		view, _, _ = strings.Cut(view, ";")
+		for _, m := range metricRegistry {
+			for _, suffix := range []string{"_avg", "_min", "_max", "_count"} {
+				if !strings.Contains(view, " AS "+m.ID+suffix+",") && !strings.Contains(view, " AS "+m.ID+suffix+"\n") {
+					t.Errorf("%s lacks %s%s", tier.table, m.ID, suffix)
+				}
+			}
+		}
+	}
+}
+
+func TestBuildTrendQuery(t *testing.T) {
+	speed, _ := lookupMetric("speed")
+	course, _ := lookupMetric("course")
+	filters := &QueryFilters{Start: trendStart, End: trendStart.Add(time.Hour)}
+	bucket := func(name string) *trendBucket {
+		for i := range trendBuckets {
+			if trendBuckets[i].name == name {
+				return &trendBuckets[i]
+			}
+		}
+		t.Fatalf("no bucket %s", name)
+		return nil
+	}
+
+	raw, args := buildTrendQuery(speed, filters, trendSpec{agg: "avg"})
+	if len(args) != 3 {
+		t.Errorf("raw samples query has %d arguments", len(args))
+	}
+	if !strings.Contains(raw, "time_iso, odometry_vehicle_speed::float8 AS value") || !strings.Contains(raw, "FROM telemetry\n") ||
+		strings.Contains(raw, "GROUP BY") {
+		t.Errorf("raw samples query:\n%s", raw)
+	}
+
+	for _, tt := range []struct {
+		m     *Metric
+		spec  trendSpec
+		wants []string
+	}{
+		{speed, trendSpec{agg: "avg", bucket: bucket("15m")}, []string{
+			"FROM telemetry_1m",
+			"time_bucket('15 minutes', bucket)",
+			"SUM(speed_avg * speed_count) / NULLIF(SUM(speed_count), 0)",
+			"bucket >= $4::timestamptz",
+			"time_bucket('1 minute', time_iso)",
+			"time_iso >= $5::timestamptz AND time_iso <= $3::timestamptz",
+		}},
+		{course, trendSpec{agg: "max", bucket: bucket("1d")}, []string{"FROM telemetry_1d", "MAX(course_max)", "MAX(gnss_course)::float8 AS course_max"}},
+		{speed, trendSpec{agg: "p95", bucket: bucket("1h")}, []string{
+			"FROM telemetry\n",
+			"time_bucket('1 hour', time_iso)",
+			"percentile_cont(0.95) WITHIN GROUP (ORDER BY odometry_vehicle_speed)",
+			"time_iso <= $3::timestamptz",
+		}},
+	} {
+		q, _ := buildTrendQuery(tt.m, filters, tt.spec)
+		for _, want := range append(tt.wants, vehicleCondition, "GROUP BY 1, 2", "ORDER BY 1, 2") {
+			if !strings.Contains(q, want) {
+				t.Errorf("%s %s per %s lacks %q:\n%s", tt.m.ID, tt.spec.agg, tt.spec.bucket.name, want, q)
+			}
+		}
+	}
+}
+
+func TestTierSpan(t *testing.T) {
+	hour := &aggregateTiers[1]
+	at := func(h, m int) time.Time {
+		return trendStart.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute)
+	}
+
+	first, last := tierSpan(hour, at(0, 30), at(3, 15))
+	if !first.Equal(at(1, 0)) || !last.Equal(at(3, 0)) {
+		t.Errorf("00:30–03:15 reads buckets %v – %v, want 01:00 – 03:00", first, last)
+	}
+	first, last = tierSpan(hour, at(1, 0), at(2, 0))
+	if !first.Equal(at(1, 0)) || !last.Equal(at(2, 0)) {
+		t.Errorf("01:00–02:00 reads buckets %v – %v, want the 01:00 bucket", first, last)
+	}
+	// No complete bucket: everything comes from raw telemetry
+	if first, last = tierSpan(hour, at(0, 10), at(1, 50)); !first.Equal(last) || !first.Equal(at(0, 10)) {
+		t.Errorf("00:10–01:50 reads buckets %v – %v, want none", first, last)
+	}
+}
+
+func TestTrendSpecMaxRows(t *testing.T) {
+	hour := &trendBuckets[3]
+	filters := &QueryFilters{Start: trendStart, End: trendStart.Add(2 * time.Hour), VehicleIDs: []string{"B183", "B208"}}
+
+	// 00:00, 01:00 and 02:00 for each vehicle
+	if got := (trendSpec{agg: "avg", bucket: hour}).maxRows(filters); got != 6 {
+		t.Errorf("two vehicles over two hours: %d rows, want 6", got)
+	}
+	// 00:30–02:00 touches the 00:00, 01:00 and 02:00 buckets
+	filters.Start = trendStart.Add(30 * time.Minute)
+	if got := (trendSpec{agg: "avg", bucket: hour}).maxRows(filters); got != 6 {
+		t.Errorf("two vehicles from 00:30 to 02:00: %d rows, want 6", got)
+	}
+	filters.End = trendStart.Add(90 * time.Minute)
+	if got := (trendSpec{agg: "avg", bucket: hour}).maxRows(filters); got != 4 {
+		t.Errorf("two vehicles from 00:30 to 01:30: %d rows, want 4", got)
+	}
+	if got := (trendSpec{agg: "avg"}).maxRows(filters); got != 0 {
+		t.Errorf("raw samples: %d rows, want unknown", got)
+	}
+	filters.VehicleIDs = nil
+	if got := (trendSpec{agg: "avg", bucket: hour}).maxRows(filters); got != 0 {
+		t.Errorf("every vehicle: %d rows, want unknown", got)
+	}
+}
@@ -9,34 +9,38 @@ import (
 )
 
 type QueryFilters struct {
-	VehicleID string
-	Start     time.Time
-	End       time.Time
+	VehicleIDs []string // nil selects the whole fleet
+	Start      time.Time
+	End        time.Time
 }
 
-var aggregatedTables = map[string]string{
-	"speed": "trend_speed_1min",
-	"temp":  "trend_temp_1min",
-	"power": "trend_power_1min",
+// vehicleCondition restricts a query to the vehicles passed as $1
+// (QueryFilters.vehicleArg).
+const vehicleCondition = "($1::text[] IS NULL OR vehicle_id = ANY($1::text[]))"
+
+// vehicleArg is the $1 argument for vehicleCondition.
+func (f *QueryFilters) vehicleArg() interface{} {
+	if f.VehicleIDs == nil {
+		return nil
+	}
+	return f.VehicleIDs
 }
 
-var aggregatedColumns = map[string]string{
-	"speed": "avg_speed",
-	"temp":  "avg_temp",
-	"power": "avg_power",
+// single reports whether exactly one vehicle was requested.
+func (f *QueryFilters) single() bool {
+	return len(f.VehicleIDs) == 1
 }
 
-func getDuration(start, end time.Time) time.Duration {
-	return end.Sub(start)
+// label names the selected vehicles in responses and logs.
+func (f *QueryFilters) label() string {
+	if f.VehicleIDs == nil {
+		return "all"
+	}
+	return strings.Join(f.VehicleIDs, ",")
 }
 
-var allowedMetrics = map[string]string{
-	"speed":          "odometry_vehicle_speed",
-	"temp":           "temperature_ambient",
-	"power":          "electric_power_demand",
-	"traction_force": "traction_traction_force",
-	"brake_pressure": "traction_brake_pressure",
-	// add others you want to expose
+func getDuration(start, end time.Time) time.Duration {
+	return end.Sub(start)
 }
 
 func parseDuration(start string, end string) (time.Duration, error) {
@@ -60,12 +64,33 @@ func parseDuration(start string, end string) (time.Duration, error) {
 	return duration, nil
 }
 
+// parseQueryFilters reads vehicle_id (one or more comma separated IDs) or
+// fleet=all, and the start/end range.
 func parseQueryFilters(c *gin.Context) (*QueryFilters, bool) {
-	vehicle := strings.TrimSpace(c.Query("vehicle_id"))
+	vehicles := strings.TrimSpace(c.Query("vehicle_id"))
+	fleet := strings.TrimSpace(c.Query("fleet"))
 	startStr := strings.TrimSpace(c.Query("start"))
 	endStr := strings.TrimSpace(c.Query("end"))
 
-	if vehicle == "" {
+	var ids []string
+	switch {
+	case fleet == "all" && vehicles == "":
+	case fleet == "" && vehicles != "":
+		seen := make(map[string]bool)
+		for _, id := range strings.Split(vehicles, ",") {
+			if id = strings.TrimSpace(id); id == "" || seen[id] {
+				continue
+			}
+			if !vehicleIDPattern.MatchString(id) {
+				return nil, false
+			}
+			seen[id] = true
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			return nil, false
+		}
+	default:
 		return nil, false
 	}
 
@@ -88,18 +113,21 @@ func parseQueryFilters(c *gin.Context) (*QueryFilters, bool) {
 	}
 
 	return &QueryFilters{
-		VehicleID: vehicle,
-		Start:     start,
-		End:       end,
+		VehicleIDs: ids,
+		Start:      start,
+		End:        end,
 	}, true
 }
 
-func validateMetric(metric string) error {
-	if metric == "" {
-		return fmt.Errorf("metric cannot be empty")
-	}
-	if _, ok := allowedMetrics[metric]; !ok {
-		return fmt.Errorf("invalid metric: %s", metric)
+// parseGroupBy reads group_by: "pooled" (default) combines the selected
+// vehicles, "vehicle" reports each one separately.
+func parseGroupBy(c *gin.Context) (perVehicle bool, err error) {
+	switch g := c.DefaultQuery("group_by", "pooled"); g {
+	case "pooled":
+		return false, nil
+	case "vehicle":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid group_by %q (pooled or vehicle)", g)
 	}
-	return nil
 }
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryContext returns a gin context for a GET request with the given query.
+func queryContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/?"+query, nil)
+	return c
+}
+
+func TestParseQueryFilters(t *testing.T) {
+	const span = "&start=2019-06-24T00:00:00Z&end=2019-06-25T00:00:00Z"
+
+	// Accepted queries and the label of their vehicle selection
+	for query, label := range map[string]string{
+		"vehicle_id=B183" + span:             "B183",
+		"vehicle_id=B183,B208" + span:        "B183,B208",
+		"vehicle_id=%20B183%20,,B183" + span: "B183",
+		"fleet=all" + span:                   "all",
+	} {
+		f, ok := parseQueryFilters(queryContext(query))
+		if !ok {
+			t.Errorf("%s: rejected", query)
+			continue
+		}
+		if f.label() != label {
+			t.Errorf("%s: label %q, want %q", query, f.label(), label)
+		}
+		if f.End.Sub(f.Start).Hours() != 24 {
+			t.Errorf("%s: range %v – %v", query, f.Start, f.End)
+		}
+	}
+
+	for _, query := range []string{
+		span[1:],
+		"vehicle_id=B183&fleet=all" + span,
+		"fleet=some" + span,
+		"vehicle_id=," + span,
+		"vehicle_id=B183,B/208" + span,
+		"vehicle_id=B183&start=2019-06-24&end=2019-06-25T00:00:00Z",
+		"vehicle_id=B183&start=2019-06-25T00:00:00Z&end=2019-06-24T00:00:00Z",
+	} {
+		if f, ok := parseQueryFilters(queryContext(query)); ok {
+			t.Errorf("%s: accepted as %+v", query, f)
+		}
+	}
+}
+
+func TestQueryFiltersVehicleArg(t *testing.T) {
+	fleet := &QueryFilters{}
+	if fleet.vehicleArg() != nil || fleet.single() {
+		t.Errorf("fleet: arg %v, single %v", fleet.vehicleArg(), fleet.single())
+	}
+
+	one := &QueryFilters{VehicleIDs: []string{"B183"}}
+	if ids, ok := one.vehicleArg().([]string); !ok || len(ids) != 1 || !one.single() {
+		t.Errorf("one vehicle: arg %#v, single %v", one.vehicleArg(), one.single())
+	}
+
+	two := &QueryFilters{VehicleIDs: []string{"B183", "B208"}}
+	if two.single() {
+		t.Error("two vehicles reported as single")
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	for query, want := range map[string]bool{"": false, "group_by=pooled": false, "group_by=vehicle": true} {
+		if got, err := parseGroupBy(queryContext(query)); err != nil || got != want {
+			t.Errorf("%q: %v, %v, want %v", query, got, err, want)
+		}
+	}
+	if _, err := parseGroupBy(queryContext("group_by=route")); err == nil {
+		t.Error("group_by=route accepted")
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rulePolicy tells what happens to a value that breaks a validation rule.
+type rulePolicy string
+
+const (
+	policyFlag   rulePolicy = "flag"   // keep the value, record a finding
+	policyClamp  rulePolicy = "clamp"  // replace the value with the violated bound
+	policyReject rulePolicy = "reject" // drop the whole row
+)
+
+// ValidationRules are the data-quality checks applied to every ingested row.
+// Sequence checks (monotonic, duplicates, max_gap) compare each row with the
+// previous row of the same vehicle in the same file. A missing rule is not
+// checked.
+type ValidationRules struct {
+	Columns    map[string]RangeRule `json:"columns,omitempty"`
+	Time       *TimeRule            `json:"time,omitempty"`
+	Monotonic  rulePolicy           `json:"monotonic,omitempty"`  // timestamp going backwards
+	Duplicates rulePolicy           `json:"duplicates,omitempty"` // timestamp equal to the previous one
+	MaxGap     *GapRule             `json:"max_gap,omitempty"`
+}
+
+// RangeRule bounds a numeric column. Either bound may be omitted.
+type RangeRule struct {
+	Min    *float64   `json:"min,omitempty"`
+	Max    *float64   `json:"max,omitempty"`
+	Policy rulePolicy `json:"policy"`
+}
+
+// TimeRule bounds time_iso. MaxFuture is a Go duration relative to the
+// ingest time, e.g. "24h".
+type TimeRule struct {
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	MaxFuture string     `json:"max_future,omitempty"`
+	Policy    rulePolicy `json:"policy"`
+
+	maxFuture time.Duration
+}
+
+// GapRule flags samples that follow the previous one after more than Max
+// (a Go duration, e.g. "60s"). A gap is a property of the data rather than of
+// a single row, so it can only be flagged.
+type GapRule struct {
+	Max    string     `json:"max"`
+	Policy rulePolicy `json:"policy"`
+
+	max time.Duration
+}
+
+func bound(v float64) *float64 { return &v }
+
+// defaultValidationRules holds plausible limits for ZTBus city buses.
+var defaultValidationRules = func() *ValidationRules {
+	r := &ValidationRules{
+		Columns: map[string]RangeRule{
+			"odometry_vehicle_speed":      {Min: bound(0), Max: bound(120), Policy: policyFlag},
+			"gnss_latitude":               {Min: bound(-90), Max: bound(90), Policy: policyReject},
+			"gnss_longitude":              {Min: bound(-180), Max: bound(180), Policy: policyReject},
+			"gnss_course":                 {Min: bound(0), Max: bound(360), Policy: policyFlag},
+			"temperature_ambient":         {Min: bound(-50), Max: bound(60), Policy: policyFlag},
+			"itcs_number_of_passengers":   {Min: bound(0), Max: bound(300), Policy: policyClamp},
+			"status_door_is_open":         {Min: bound(0), Max: bound(1), Policy: policyClamp},
+			"status_grid_is_available":    {Min: bound(0), Max: bound(1), Policy: policyClamp},
+			"status_halt_brake_is_active": {Min: bound(0), Max: bound(1), Policy: policyClamp},
+			"status_park_brake_is_active": {Min: bound(0), Max: bound(1), Policy: policyClamp},
+		},
+		Time: &TimeRule{
+			NotBefore: func() *time.Time { t := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC); return &t }(),
+			MaxFuture: "24h",
+			Policy:    policyReject,
+		},
+		Monotonic:  policyFlag,
+		Duplicates: policyFlag,
+		MaxGap:     &GapRule{Max: "60s", Policy: policyFlag},
+	}
+	if err := r.validate(); err != nil {
+		panic("default validation rules: " + err.Error())
+	}
+	return r
+}()
+
+func (p rulePolicy) valid(allowClamp bool) bool {
+	switch p {
+	case policyFlag, policyReject:
+		return true
+	case policyClamp:
+		return allowClamp
+	}
+	return false
+}
+
+// isRangeColumn reports whether a range rule can apply to col.
+func isRangeColumn(col string) bool {
+	typ, ok := telemetryColumnTypes[col]
+	if !ok || typ.Kind() != reflect.Ptr {
+		return false
+	}
+	switch typ.Elem().Kind() {
+	case reflect.Float64, reflect.Int, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// validate checks the rules and parses their durations.
+func (r *ValidationRules) validate() error {
+	for col, rule := range r.Columns {
+		if !isRangeColumn(col) {
+			return fmt.Errorf("range rule on %q: only numeric columns can be checked", col)
+		}
+		if rule.Min == nil && rule.Max == nil {
+			return fmt.Errorf("range rule on %q: min or max is required", col)
+		}
+		if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+			return fmt.Errorf("range rule on %q: min is greater than max", col)
+		}
+		if !rule.Policy.valid(true) {
+			return fmt.Errorf("range rule on %q: invalid policy %q, must be flag, clamp or reject", col, rule.Policy)
+		}
+	}
+	if r.Time != nil {
+		if !r.Time.Policy.valid(false) {
+			return fmt.Errorf("time rule: invalid policy %q, must be flag or reject", r.Time.Policy)
+		}
+		if r.Time.MaxFuture != "" {
+			d, err := time.ParseDuration(r.Time.MaxFuture)
+			if err != nil || d < 0 {
+				return fmt.Errorf("time rule: invalid max_future %q", r.Time.MaxFuture)
+			}
+			r.Time.maxFuture = d
+		}
+		if r.Time.NotBefore == nil && r.Time.MaxFuture == "" {
+			return fmt.Errorf("time rule: not_before or max_future is required")
+		}
+	}
+	for name, p := range map[string]rulePolicy{"monotonic": r.Monotonic, "duplicates": r.Duplicates} {
+		if p != "" && !p.valid(false) {
+			return fmt.Errorf("%s: invalid policy %q, must be flag or reject", name, p)
+		}
+	}
+	if r.MaxGap != nil {
+		if r.MaxGap.Policy != policyFlag {
+			return fmt.Errorf("max_gap: invalid policy %q, must be flag", r.MaxGap.Policy)
+		}
+		d, err := time.ParseDuration(r.MaxGap.Max)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("max_gap: invalid max %q", r.MaxGap.Max)
+		}
+		r.MaxGap.max = d
+	}
+	return nil
+}
+
+type rulesStore struct {
+	mu    sync.RWMutex
+	rules *ValidationRules
+	path  string // file API changes are persisted to, if set
+}
+
+var validationRules = &rulesStore{rules: defaultValidationRules}
+
+// LoadValidationRules replaces the default rules with the JSON file named by
+// INGEST_RULES_FILE. Rules changed through the API are written back to it.
+func LoadValidationRules() error {
+	path := os.Getenv("INGEST_RULES_FILE")
+	if path == "" {
+		return nil
+	}
+	validationRules.path = path
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		slog.Info("validation rules file not found, using default rules", "path", path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read validation rules: %w", err)
+	}
+
+	var rules ValidationRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("parse validation rules: %w", err)
+	}
+	if err := rules.validate(); err != nil {
+		return err
+	}
+
+	validationRules.mu.Lock()
+	validationRules.rules = &rules
+	validationRules.mu.Unlock()
+
+	slog.Info("validation rules loaded", "path", path, "columns", len(rules.Columns))
+	return nil
+}
+
+// get returns the current rules. They are never mutated, so a running
+// ingest keeps the version it started with.
+func (s *rulesStore) get() *ValidationRules {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rules
+}
+
+func (s *rulesStore) put(r *ValidationRules) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = r
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+func GetValidationRules(c *gin.Context) {
+	c.JSON(http.StatusOK, validationRules.get())
+}
+
+func PutValidationRules(c *gin.Context) {
+	var rules ValidationRules
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		slog.Warn("invalid validation rules body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rules: " + err.Error()})
+		return
+	}
+	if err := rules.validate(); err != nil {
+		slog.Warn("invalid validation rules", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validationRules.put(&rules); err != nil {
+		slog.Error("failed to persist validation rules", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save rules"})
+		return
+	}
+
+	slog.Info("validation rules updated", "columns", len(rules.Columns))
+	c.JSON(http.StatusOK, &rules)
+}
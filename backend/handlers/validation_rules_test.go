@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidationRulesValidate(t *testing.T) {
+	valid := `{"columns": {"gnss_course": {"min": 0, "max": 360, "policy": "clamp"}},
+		"time": {"max_future": "1h", "policy": "flag"}, "monotonic": "reject", "max_gap": {"max": "30s", "policy": "flag"}}`
+	var rules ValidationRules
+	if err := json.Unmarshal([]byte(valid), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if err := rules.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if rules.Time.maxFuture != time.Hour || rules.MaxGap.max != 30*time.Second {
+		t.Errorf("durations not parsed: %v, %v", rules.Time.maxFuture, rules.MaxGap.max)
+	}
+
+	invalid := map[string]string{
+		`{"columns": {"itcs_stop_name": {"max": 1, "policy": "flag"}}}`:        "only numeric columns",
+		`{"columns": {"gnss_course": {"policy": "flag"}}}`:                     "min or max is required",
+		`{"columns": {"gnss_course": {"min": 2, "max": 1, "policy": "flag"}}}`: "min is greater than max",
+		`{"columns": {"gnss_course": {"max": 1, "policy": "drop"}}}`:           "invalid policy",
+		`{"time": {"max_future": "1h", "policy": "clamp"}}`:                    "must be flag or reject",
+		`{"time": {"max_future": "soon", "policy": "flag"}}`:                   "invalid max_future",
+		`{"time": {"policy": "flag"}}`:                                         "not_before or max_future is required",
+		`{"duplicates": "clamp"}`:                                              "duplicates: invalid policy",
+		`{"max_gap": {"max": "60s", "policy": "reject"}}`:                      "must be flag",
+		`{"max_gap": {"max": "0s", "policy": "flag"}}`:                         "invalid max",
+	}
+	for body, want := range invalid {
+		var r ValidationRules
+		if err := json.Unmarshal([]byte(body), &r); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		if err := r.validate(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", body, err, want)
+		}
+	}
+}
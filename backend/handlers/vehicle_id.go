@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Vehicle IDs are short alphanumeric codes such as "B183".
+var vehicleIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// ztbusFilenamePattern extracts the vehicle and the declared mission range
+// from ZTBus mission file names.
+// Example: B183_2019-06-24_03-16-13_2019-06-24_18-54-06.csv
+const ztbusFilenamePattern = `^(?P<vehicle>[A-Za-z0-9-]+)_` +
+	`(?:(?P<start>\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2})_(?P<end>\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}))?`
+
+// ztbusFilenameTimeLayout is the time layout of the start/end groups above.
+const ztbusFilenameTimeLayout = "2006-01-02_15-04-05"
+
+// allowedVehicles restricts ingest to known vehicles when INGEST_ALLOWED_VEHICLES
+// (a comma separated list) is set. Nil means every well-formed ID is accepted.
+var allowedVehicles = func() map[string]bool {
+	raw := os.Getenv("INGEST_ALLOWED_VEHICLES")
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	out := make(map[string]bool)
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out[v] = true
+		}
+	}
+	return out
+}()
+
+// Where an upload's vehicle ID came from.
+const (
+	vehicleFromForm     = "form"
+	vehicleFromFilename = "filename"
+	vehicleFromColumn   = "column"
+)
+
+func validateVehicleID(id string) error {
+	if !vehicleIDPattern.MatchString(id) {
+		return fmt.Errorf("malformed vehicle_id: %q", id)
+	}
+	if allowedVehicles != nil && !allowedVehicles[id] {
+		return fmt.Errorf("unknown vehicle_id: %q", id)
+	}
+	return nil
+}
+
+// resolveVehicleID determines the vehicle of an upload. An explicit form value
+// wins, then the profile's filename pattern. When neither applies but the
+// profile maps a vehicle_id column, the ID is read per row and "" is returned.
+func resolveVehicleID(formValue, filename string, profile *MappingProfile) (id, source string, err error) {
+	if formValue = strings.TrimSpace(formValue); formValue != "" {
+		if err := validateVehicleID(formValue); err != nil {
+			return "", "", err
+		}
+		return formValue, vehicleFromForm, nil
+	}
+
+	if re := profile.filenameRegexp(); re != nil {
+		if m := re.FindStringSubmatch(filename); m != nil {
+			id := m[re.SubexpIndex("vehicle")]
+			if err := validateVehicleID(id); err != nil {
+				return "", "", fmt.Errorf("vehicle from filename %q: %w", filename, err)
+			}
+			return id, vehicleFromFilename, nil
+		}
+	}
+
+	if profile.mapsColumn("vehicle_id") {
+		return "", vehicleFromColumn, nil
+	}
+
+	return "", "", fmt.Errorf("could not determine vehicle for %q: pass a vehicle_id field or use a profile with a matching filename_pattern", filename)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import "testing"
+
+func TestValidateVehicleID(t *testing.T) {
+	for _, id := range []string{"B183", "b-208", "T_1"} {
+		if err := validateVehicleID(id); err != nil {
+			t.Errorf("validateVehicleID(%q) = %v", id, err)
+		}
+	}
+	for _, id := range []string{"", "_B183", "B 183", "B183;DROP", "B/183"} {
+		if err := validateVehicleID(id); err == nil {
+			t.Errorf("validateVehicleID(%q) accepted a malformed ID", id)
+		}
+	}
+
+	defer func(prev map[string]bool) { allowedVehicles = prev }(allowedVehicles)
+	allowedVehicles = map[string]bool{"B183": true}
+	if err := validateVehicleID("B183"); err != nil {
+		t.Errorf("allowed vehicle: %v", err)
+	}
+	if err := validateVehicleID("B208"); err == nil {
+		t.Error("vehicle outside INGEST_ALLOWED_VEHICLES was accepted")
+	}
+}
+
+func TestResolveVehicleID(t *testing.T) {
+	withColumn := &MappingProfile{Name: "fleet", Columns: map[string]string{"bus": "vehicle_id", "ts": "time_iso"}}
+	neither := &MappingProfile{Name: "plain", Columns: map[string]string{"ts": "time_iso"}}
+
+	tests := []struct {
+		form, filename string
+		profile        *MappingProfile
+		id, source     string
+		wantErr        bool
+	}{
+		{"", "B183_2019-06-24_03-16-13_2019-06-24_18-54-06.csv", ztbusProfile, "B183", vehicleFromFilename, false},
+		{" B208 ", "B183_2019-06-24.csv", ztbusProfile, "B208", vehicleFromForm, false},
+		{"", "export.csv", withColumn, "", vehicleFromColumn, false},
+		{"B183", "export.csv", withColumn, "B183", vehicleFromForm, false},
+		{"", "export.csv", neither, "", "", true},
+		{"", "B 183_x.csv", ztbusProfile, "", "", true}, // pattern does not match
+		{"B/183", "B183_x.csv", ztbusProfile, "", "", true},
+	}
+	for _, tt := range tests {
+		id, source, err := resolveVehicleID(tt.form, tt.filename, tt.profile)
+		if (err != nil) != tt.wantErr || id != tt.id || source != tt.source {
+			t.Errorf("resolveVehicleID(%q, %q, %s) = %q, %q, %v, want %q, %q",
+				tt.form, tt.filename, tt.profile.Name, id, source, err, tt.id, tt.source)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"telemetry-dashboard/my_structs"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Number of missions returned by GET /vehicles/:id.
+const latestMissionCount = 10
+
+// vehicleSummaryQuery summarizes vehicles from the vehicle_activity_1day
+// continuous aggregate, optionally restricted to the vehicle in $1.
+const vehicleSummaryQuery = `
+	WITH activity AS (
+		SELECT vehicle_id,
+		       MIN(first_sample) AS first_sample,
+		       MAX(last_sample) AS last_sample,
+		       SUM(row_count)::bigint AS row_count,
+		       COALESCE(array_agg(DISTINCT route ORDER BY route) FILTER (WHERE route IS NOT NULL), '{}') AS routes
+		FROM vehicle_activity_1day
+		WHERE $1::text IS NULL OR vehicle_id = $1
+		GROUP BY vehicle_id
+	), mission_counts AS (
+		SELECT vehicle_id, COUNT(*) AS mission_count
+		FROM missions
+		WHERE $1::text IS NULL OR vehicle_id = $1
+		GROUP BY vehicle_id
+	)
+	SELECT a.vehicle_id, a.first_sample, a.last_sample, a.row_count,
+	       COALESCE(m.mission_count, 0), a.routes
+	FROM activity a
+	LEFT JOIN mission_counts m USING (vehicle_id)
+	ORDER BY a.vehicle_id
+`
+
+func scanVehicle(row pgx.Row) (my_structs.Vehicle, error) {
+	var v my_structs.Vehicle
+	err := row.Scan(&v.ID, &v.FirstSample, &v.LastSample, &v.RowCount, &v.MissionCount, &v.Routes)
+	return v, err
+}
+
+// GetVehicles lists every vehicle with telemetry.
+func GetVehicles(c *gin.Context, pool *pgxpool.Pool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := pool.Query(ctx, vehicleSummaryQuery, nil)
+	if err != nil {
+		slog.Error("vehicles query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	defer rows.Close()
+
+	out := []my_structs.Vehicle{}
+	for rows.Next() {
+		v, err := scanVehicle(rows)
+		if err != nil {
+			slog.Error("row scan failed inside vehicles", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
+			return
+		}
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("vehicles query failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	slog.Info("vehicles listed", "count", len(out))
+	c.JSON(http.StatusOK, out)
+}
+
+// GetVehicle returns a vehicle summary with its activity per route and its
+// latest missions.
+func GetVehicle(c *gin.Context, pool *pgxpool.Pool) {
+	id := c.Param("id")
+	if !vehicleIDPattern.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vehicle id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	v, err := scanVehicle(pool.QueryRow(ctx, vehicleSummaryQuery, id))
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "vehicle not found"})
+		return
+	}
+	if err != nil {
+		slog.Error("vehicle query failed", "error", err, "vehicle", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	details := my_structs.VehicleDetails{Vehicle: v, RouteStats: []my_structs.VehicleRoute{}, LatestMissions: []my_structs.Mission{}}
+
+	err = pool.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT bucket) FROM vehicle_activity_1day WHERE vehicle_id = $1
+	`, id).Scan(&details.ActiveDays)
+	if err != nil {
+		slog.Error("vehicle activity query failed", "error", err, "vehicle", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT route, MIN(first_sample), MAX(last_sample), SUM(row_count)::bigint, COUNT(DISTINCT bucket)
+		FROM vehicle_activity_1day
+		WHERE vehicle_id = $1 AND route IS NOT NULL
+		GROUP BY route
+		ORDER BY route
+	`, id)
+	if err != nil {
+		slog.Error("vehicle routes query failed", "error", err, "vehicle", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	for rows.Next() {
+		var r my_structs.VehicleRoute
+		if err := rows.Scan(&r.Route, &r.FirstSample, &r.LastSample, &r.RowCount, &r.ActiveDays); err != nil {
+			rows.Close()
+			slog.Error("row scan failed inside vehicle routes", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
+			return
+		}
+		details.RouteStats = append(details.RouteStats, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		slog.Error("vehicle routes query failed", "error", err, "vehicle", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	rows, err = pool.Query(ctx, "SELECT "+missionColumns+" FROM missions WHERE vehicle_id = $1 ORDER BY uploaded_at DESC, id DESC LIMIT $2", id, latestMissionCount)
+	if err != nil {
+		slog.Error("vehicle missions query failed", "error", err, "vehicle", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanMission(rows)
+		if err != nil {
+			slog.Error("row scan failed inside vehicle missions", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "scan failed"})
+			return
+		}
+		details.LatestMissions = append(details.LatestMissions, m)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("vehicle missions query failed", "error", err, "vehicle", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, details)
+}
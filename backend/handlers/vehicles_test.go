@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetVehicleInvalidID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// Invalid IDs are refused before the database is queried
+	r.GET("/vehicles/:id", func(c *gin.Context) { GetVehicle(c, nil) })
+
+	for _, id := range []string{"_B183", "B183%27", "%20", "B183%3BDROP", "B183.csv"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/vehicles/"+id, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET /vehicles/%s = %d, want 400", id, w.Code)
+		}
+	}
+}
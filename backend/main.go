@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"telemetry-dashboard/db"
 	"telemetry-dashboard/handlers"
@@ -13,6 +19,10 @@ import (
 )
 
 func main() {
+	// Cancelled on SIGINT/SIGTERM to stop the server and the stream ingest
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	conn, err := db.Connect()
 	if err != nil {
 		log.Fatalf("DB connection failed: %v", err)
@@ -22,7 +32,7 @@ func main() {
 	router := gin.Default()
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, //TODO change with frontend URL
-		AllowMethods:     []string{"POST", "GET", "OPTIONS"},
+		AllowMethods:     []string{"POST", "GET", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Host", "User-Agent", "Authorization", "Origin", "Accept", "Accept-Encoding", "Content-Length", "Content-Type", "Content type", "Connection"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -38,15 +48,76 @@ func main() {
 
 	slog.Info("logger initialized", "level", "INFO", "format", "JSON")
 
-	router.POST("/ingest-csv", func(c *gin.Context) { handlers.IngestCSV(c, conn) })
-	router.GET("/live-trend", func(c *gin.Context) { handlers.LiveTrend(c, conn) })
-	router.GET("/kpis", func(c *gin.Context) { handlers.GetKPIs(c, conn) })
-	router.GET("/trend", func(c *gin.Context) { handlers.GetTrend(c, conn) })
-	router.GET("/distribution", func(c *gin.Context) { handlers.GetDistribution(c, conn) })
-	router.GET("/stats", func(c *gin.Context) { handlers.GetStats(c, conn) })
+	// BACKEND_MODE=consumer runs only the Kafka consumer, without the API
+	mode := os.Getenv("BACKEND_MODE")
+	switch mode {
+	case "", "server":
+		mode = "server"
+	case "consumer":
+		if !handlers.KafkaConsumerEnabled() {
+			log.Fatalf("BACKEND_MODE=consumer requires KAFKA_BROKERS")
+		}
+	default:
+		log.Fatalf("Invalid BACKEND_MODE %q (server or consumer)", mode)
+	}
+
+	if err := handlers.LoadValidationRules(); err != nil {
+		log.Fatalf("Loading validation rules failed: %v", err)
+	}
+	if err := handlers.StartKafkaConsumer(ctx, conn); err != nil {
+		log.Fatalf("Starting Kafka consumer failed: %v", err)
+	}
+	router.GET("/kafka/lag", handlers.GetKafkaLag)
+
+	if mode == "server" {
+		if err := handlers.LoadMappingProfiles(); err != nil {
+			log.Fatalf("Loading mapping profiles failed: %v", err)
+		}
+		handlers.StartIngestWorkers(conn)
+		if err := handlers.StartMQTTBridge(ctx, conn); err != nil {
+			log.Fatalf("Starting MQTT bridge failed: %v", err)
+		}
+
+		router.POST("/ingest-csv", func(c *gin.Context) { handlers.IngestCSV(c, conn) })
+		router.POST("/ingest", func(c *gin.Context) { handlers.IngestRecords(c, conn) })
+		router.GET("/ingest-jobs", handlers.ListIngestJobs)
+		router.GET("/ingest-jobs/:id", handlers.GetIngestJob)
+		router.GET("/ingest-jobs/:id/errors", handlers.GetIngestJobErrors)
+		router.GET("/mapping-profiles", handlers.ListMappingProfiles)
+		router.GET("/mapping-profiles/:name", handlers.GetMappingProfile)
+		router.PUT("/mapping-profiles/:name", handlers.PutMappingProfile)
+		router.DELETE("/mapping-profiles/:name", handlers.DeleteMappingProfile)
+		router.GET("/validation-rules", handlers.GetValidationRules)
+		router.PUT("/validation-rules", handlers.PutValidationRules)
+		router.GET("/metrics", handlers.ListMetrics)
+		router.GET("/live-trend", func(c *gin.Context) { handlers.LiveTrend(c, conn) })
+		router.GET("/kpi-definitions", handlers.ListKPIs)
+		router.GET("/kpis", func(c *gin.Context) { handlers.GetKPIs(c, conn) })
+		router.GET("/trend", func(c *gin.Context) { handlers.GetTrend(c, conn) })
+		router.GET("/distribution", func(c *gin.Context) { handlers.GetDistribution(c, conn) })
+		router.GET("/track", func(c *gin.Context) { handlers.GetTrack(c, conn) })
+		router.GET("/heatmap", func(c *gin.Context) { handlers.GetHeatmap(c, conn) })
+		router.GET("/stats", func(c *gin.Context) { handlers.GetStats(c, conn) })
+		router.GET("/vehicles", func(c *gin.Context) { handlers.GetVehicles(c, conn) })
+		router.GET("/vehicles/:id", func(c *gin.Context) { handlers.GetVehicle(c, conn) })
+		router.GET("/missions", func(c *gin.Context) { handlers.GetMissions(c, conn) })
+		router.GET("/missions/:id", func(c *gin.Context) { handlers.GetMission(c, conn) })
+		router.GET("/missions/:id/quality", func(c *gin.Context) { handlers.GetMissionQuality(c, conn) })
+	}
+
+	srv := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("server shutdown failed", "error", err)
+		}
+	}()
 
-	log.Println("Server running at :8080")
-	if err := router.Run(":8080"); err != nil {
+	log.Printf("Server running at :8080 (%s mode)", mode)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed: %v", err)
 	}
+	slog.Info("server stopped")
 }
@@ -0,0 +1,20 @@
+package my_structs
+
+import "time"
+
+// Mission is one ingested source file (per vehicle) and the telemetry range it covered.
+type Mission struct {
+	ID             int64      `json:"id" db:"id"`
+	VehicleID      string     `json:"vehicle_id" db:"vehicle_id"`
+	DeclaredStart  *time.Time `json:"declared_start,omitempty" db:"declared_start"`
+	DeclaredEnd    *time.Time `json:"declared_end,omitempty" db:"declared_end"`
+	FirstSample    *time.Time `json:"first_sample,omitempty" db:"first_sample"`
+	LastSample     *time.Time `json:"last_sample,omitempty" db:"last_sample"`
+	RowCount       int64      `json:"row_count" db:"row_count"`
+	SourceFilename string     `json:"source_filename" db:"source_filename"`
+	UploadedAt     time.Time  `json:"uploaded_at" db:"uploaded_at"`
+	Checksum       string     `json:"checksum" db:"checksum"`
+	QualityScore   *float64   `json:"quality_score" db:"quality_score"` // % of parsed rows without findings
+	FindingCount   int64      `json:"finding_count" db:"finding_count"`
+	RejectedRows   int64      `json:"rejected_rows" db:"rejected_rows"`
+}
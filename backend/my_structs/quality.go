@@ -0,0 +1,15 @@
+package my_structs
+
+import "time"
+
+// QualityFinding is a validation rule violation found while ingesting a mission.
+type QualityFinding struct {
+	MissionID int64      `json:"mission_id" db:"mission_id"`
+	Line      int        `json:"line" db:"line"`
+	TimeISO   *time.Time `json:"time_iso,omitempty" db:"time_iso"`
+	Rule      string     `json:"rule" db:"rule"`
+	Column    string     `json:"column,omitempty" db:"column_name"`
+	Action    string     `json:"action" db:"action"`
+	Value     *float64   `json:"value,omitempty" db:"value"`
+	Detail    string     `json:"detail" db:"detail"`
+}
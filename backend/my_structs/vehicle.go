@@ -0,0 +1,30 @@
+package my_structs
+
+import "time"
+
+// Vehicle summarizes the telemetry stored for one vehicle.
+type Vehicle struct {
+	ID           string     `json:"id" db:"vehicle_id"`
+	FirstSample  *time.Time `json:"first_sample" db:"first_sample"`
+	LastSample   *time.Time `json:"last_sample" db:"last_sample"`
+	RowCount     int64      `json:"row_count" db:"row_count"`
+	MissionCount int64      `json:"mission_count" db:"mission_count"`
+	Routes       []string   `json:"routes" db:"routes"`
+}
+
+// VehicleRoute is a vehicle's activity on one route.
+type VehicleRoute struct {
+	Route       string    `json:"route" db:"route"`
+	FirstSample time.Time `json:"first_sample" db:"first_sample"`
+	LastSample  time.Time `json:"last_sample" db:"last_sample"`
+	RowCount    int64     `json:"row_count" db:"row_count"`
+	ActiveDays  int64     `json:"active_days" db:"active_days"`
+}
+
+// VehicleDetails is a vehicle summary with its per-route activity and latest missions.
+type VehicleDetails struct {
+	Vehicle
+	ActiveDays     int64          `json:"active_days"`
+	RouteStats     []VehicleRoute `json:"route_stats"`
+	LatestMissions []Mission      `json:"latest_missions"`
+}
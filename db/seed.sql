@@ -36,6 +36,42 @@ CREATE TABLE IF NOT EXISTS telemetry (
 -- Convert the table into a hypertable
 SELECT create_hypertable('telemetry', 'time_iso', if_not_exists => TRUE);
 
+-- Missions: one row per ingested source file and vehicle
+CREATE TABLE IF NOT EXISTS missions (
+    id BIGSERIAL PRIMARY KEY,
+    vehicle_id TEXT NOT NULL,
+    declared_start TIMESTAMPTZ,
+    declared_end TIMESTAMPTZ,
+    first_sample TIMESTAMPTZ,
+    last_sample TIMESTAMPTZ,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    source_filename TEXT NOT NULL,
+    uploaded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    checksum TEXT NOT NULL,
+    quality_score DOUBLE PRECISION,
+    finding_count BIGINT NOT NULL DEFAULT 0,
+    rejected_rows BIGINT NOT NULL DEFAULT 0,
+    UNIQUE (vehicle_id, checksum)
+);
+
+CREATE INDEX IF NOT EXISTS missions_vehicle_sample_idx
+    ON missions (vehicle_id, first_sample, last_sample);
+
+-- Data-quality findings of the validation rules, per mission
+CREATE TABLE IF NOT EXISTS quality_findings (
+    mission_id BIGINT NOT NULL REFERENCES missions (id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    time_iso TIMESTAMPTZ,
+    rule TEXT NOT NULL,
+    column_name TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    value DOUBLE PRECISION,
+    detail TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS quality_findings_mission_idx
+    ON quality_findings (mission_id, line);
+
 -- Enable compression for old chunks (compress after 7 days)
 -- Might be problematic for static old data
 -- ALTER TABLE telemetry SET (
@@ -47,51 +83,339 @@ SELECT create_hypertable('telemetry', 'time_iso', if_not_exists => TRUE);
 
 -- Continuous aggregates
 
--- Speed
-CREATE MATERIALIZED VIEW IF NOT EXISTS trend_speed_1min
-WITH (timescaledb.continuous) AS
+-- Per-metric aggregates of earlier versions, replaced by the tiers below.
+-- Dropping them also removes their refresh policies.
+DROP MATERIALIZED VIEW IF EXISTS trend_speed_1min CASCADE;
+DROP MATERIALIZED VIEW IF EXISTS trend_temp_1min CASCADE;
+DROP MATERIALIZED VIEW IF EXISTS trend_power_1min CASCADE;
+
+-- Hierarchical metric aggregates: telemetry_1m -> telemetry_1h -> telemetry_1d.
+-- Every metric of the backend metric registry (handlers/metrics.go) has
+-- <id>_avg, <id>_min, <id>_max and <id>_count columns in each tier; coarser
+-- tiers weight averages by count so they stay exact.
+CREATE MATERIALIZED VIEW IF NOT EXISTS telemetry_1m
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
 SELECT time_bucket('1 minute', time_iso) AS bucket,
        vehicle_id,
-       AVG(odometry_vehicle_speed) AS avg_speed
+       AVG(odometry_vehicle_speed)::double precision AS speed_avg,
+       MIN(odometry_vehicle_speed)::double precision AS speed_min,
+       MAX(odometry_vehicle_speed)::double precision AS speed_max,
+       COUNT(odometry_vehicle_speed) AS speed_count,
+       AVG(electric_power_demand)::double precision AS power_avg,
+       MIN(electric_power_demand)::double precision AS power_min,
+       MAX(electric_power_demand)::double precision AS power_max,
+       COUNT(electric_power_demand) AS power_count,
+       AVG(temperature_ambient)::double precision AS temp_avg,
+       MIN(temperature_ambient)::double precision AS temp_min,
+       MAX(temperature_ambient)::double precision AS temp_max,
+       COUNT(temperature_ambient) AS temp_count,
+       AVG(traction_traction_force)::double precision AS traction_force_avg,
+       MIN(traction_traction_force)::double precision AS traction_force_min,
+       MAX(traction_traction_force)::double precision AS traction_force_max,
+       COUNT(traction_traction_force) AS traction_force_count,
+       AVG(traction_brake_pressure)::double precision AS brake_pressure_avg,
+       MIN(traction_brake_pressure)::double precision AS brake_pressure_min,
+       MAX(traction_brake_pressure)::double precision AS brake_pressure_max,
+       COUNT(traction_brake_pressure) AS brake_pressure_count,
+       AVG(itcs_number_of_passengers)::double precision AS passengers_avg,
+       MIN(itcs_number_of_passengers)::double precision AS passengers_min,
+       MAX(itcs_number_of_passengers)::double precision AS passengers_max,
+       COUNT(itcs_number_of_passengers) AS passengers_count,
+       AVG(gnss_altitude)::double precision AS altitude_avg,
+       MIN(gnss_altitude)::double precision AS altitude_min,
+       MAX(gnss_altitude)::double precision AS altitude_max,
+       COUNT(gnss_altitude) AS altitude_count,
+       AVG(gnss_course)::double precision AS course_avg,
+       MIN(gnss_course)::double precision AS course_min,
+       MAX(gnss_course)::double precision AS course_max,
+       COUNT(gnss_course) AS course_count,
+       AVG(gnss_latitude)::double precision AS latitude_avg,
+       MIN(gnss_latitude)::double precision AS latitude_min,
+       MAX(gnss_latitude)::double precision AS latitude_max,
+       COUNT(gnss_latitude) AS latitude_count,
+       AVG(gnss_longitude)::double precision AS longitude_avg,
+       MIN(gnss_longitude)::double precision AS longitude_min,
+       MAX(gnss_longitude)::double precision AS longitude_max,
+       COUNT(gnss_longitude) AS longitude_count,
+       AVG(odometry_articulation_angle)::double precision AS articulation_angle_avg,
+       MIN(odometry_articulation_angle)::double precision AS articulation_angle_min,
+       MAX(odometry_articulation_angle)::double precision AS articulation_angle_max,
+       COUNT(odometry_articulation_angle) AS articulation_angle_count,
+       AVG(odometry_steering_angle)::double precision AS steering_angle_avg,
+       MIN(odometry_steering_angle)::double precision AS steering_angle_min,
+       MAX(odometry_steering_angle)::double precision AS steering_angle_max,
+       COUNT(odometry_steering_angle) AS steering_angle_count,
+       AVG(odometry_wheel_speed_fl)::double precision AS wheel_speed_fl_avg,
+       MIN(odometry_wheel_speed_fl)::double precision AS wheel_speed_fl_min,
+       MAX(odometry_wheel_speed_fl)::double precision AS wheel_speed_fl_max,
+       COUNT(odometry_wheel_speed_fl) AS wheel_speed_fl_count,
+       AVG(odometry_wheel_speed_fr)::double precision AS wheel_speed_fr_avg,
+       MIN(odometry_wheel_speed_fr)::double precision AS wheel_speed_fr_min,
+       MAX(odometry_wheel_speed_fr)::double precision AS wheel_speed_fr_max,
+       COUNT(odometry_wheel_speed_fr) AS wheel_speed_fr_count,
+       AVG(odometry_wheel_speed_ml)::double precision AS wheel_speed_ml_avg,
+       MIN(odometry_wheel_speed_ml)::double precision AS wheel_speed_ml_min,
+       MAX(odometry_wheel_speed_ml)::double precision AS wheel_speed_ml_max,
+       COUNT(odometry_wheel_speed_ml) AS wheel_speed_ml_count,
+       AVG(odometry_wheel_speed_mr)::double precision AS wheel_speed_mr_avg,
+       MIN(odometry_wheel_speed_mr)::double precision AS wheel_speed_mr_min,
+       MAX(odometry_wheel_speed_mr)::double precision AS wheel_speed_mr_max,
+       COUNT(odometry_wheel_speed_mr) AS wheel_speed_mr_count,
+       AVG(odometry_wheel_speed_rl)::double precision AS wheel_speed_rl_avg,
+       MIN(odometry_wheel_speed_rl)::double precision AS wheel_speed_rl_min,
+       MAX(odometry_wheel_speed_rl)::double precision AS wheel_speed_rl_max,
+       COUNT(odometry_wheel_speed_rl) AS wheel_speed_rl_count,
+       AVG(odometry_wheel_speed_rr)::double precision AS wheel_speed_rr_avg,
+       MIN(odometry_wheel_speed_rr)::double precision AS wheel_speed_rr_min,
+       MAX(odometry_wheel_speed_rr)::double precision AS wheel_speed_rr_max,
+       COUNT(odometry_wheel_speed_rr) AS wheel_speed_rr_count,
+       AVG(status_door_is_open)::double precision AS door_open_avg,
+       MIN(status_door_is_open)::double precision AS door_open_min,
+       MAX(status_door_is_open)::double precision AS door_open_max,
+       COUNT(status_door_is_open) AS door_open_count,
+       AVG(status_grid_is_available)::double precision AS grid_available_avg,
+       MIN(status_grid_is_available)::double precision AS grid_available_min,
+       MAX(status_grid_is_available)::double precision AS grid_available_max,
+       COUNT(status_grid_is_available) AS grid_available_count,
+       AVG(status_halt_brake_is_active)::double precision AS halt_brake_avg,
+       MIN(status_halt_brake_is_active)::double precision AS halt_brake_min,
+       MAX(status_halt_brake_is_active)::double precision AS halt_brake_max,
+       COUNT(status_halt_brake_is_active) AS halt_brake_count,
+       AVG(status_park_brake_is_active)::double precision AS park_brake_avg,
+       MIN(status_park_brake_is_active)::double precision AS park_brake_min,
+       MAX(status_park_brake_is_active)::double precision AS park_brake_max,
+       COUNT(status_park_brake_is_active) AS park_brake_count
 FROM telemetry
 GROUP BY bucket, vehicle_id;
 
--- Temp
-CREATE MATERIALIZED VIEW IF NOT EXISTS trend_temp_1min
-WITH (timescaledb.continuous) AS
-SELECT time_bucket('1 minute', time_iso) AS bucket,
+CREATE MATERIALIZED VIEW IF NOT EXISTS telemetry_1h
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 hour', bucket) AS bucket,
        vehicle_id,
-       AVG(temperature_ambient) AS avg_temp
-FROM telemetry
-GROUP BY bucket, vehicle_id;
+       SUM(speed_avg * speed_count) / NULLIF(SUM(speed_count), 0) AS speed_avg,
+       MIN(speed_min) AS speed_min,
+       MAX(speed_max) AS speed_max,
+       SUM(speed_count)::bigint AS speed_count,
+       SUM(power_avg * power_count) / NULLIF(SUM(power_count), 0) AS power_avg,
+       MIN(power_min) AS power_min,
+       MAX(power_max) AS power_max,
+       SUM(power_count)::bigint AS power_count,
+       SUM(temp_avg * temp_count) / NULLIF(SUM(temp_count), 0) AS temp_avg,
+       MIN(temp_min) AS temp_min,
+       MAX(temp_max) AS temp_max,
+       SUM(temp_count)::bigint AS temp_count,
+       SUM(traction_force_avg * traction_force_count) / NULLIF(SUM(traction_force_count), 0) AS traction_force_avg,
+       MIN(traction_force_min) AS traction_force_min,
+       MAX(traction_force_max) AS traction_force_max,
+       SUM(traction_force_count)::bigint AS traction_force_count,
+       SUM(brake_pressure_avg * brake_pressure_count) / NULLIF(SUM(brake_pressure_count), 0) AS brake_pressure_avg,
+       MIN(brake_pressure_min) AS brake_pressure_min,
+       MAX(brake_pressure_max) AS brake_pressure_max,
+       SUM(brake_pressure_count)::bigint AS brake_pressure_count,
+       SUM(passengers_avg * passengers_count) / NULLIF(SUM(passengers_count), 0) AS passengers_avg,
+       MIN(passengers_min) AS passengers_min,
+       MAX(passengers_max) AS passengers_max,
+       SUM(passengers_count)::bigint AS passengers_count,
+       SUM(altitude_avg * altitude_count) / NULLIF(SUM(altitude_count), 0) AS altitude_avg,
+       MIN(altitude_min) AS altitude_min,
+       MAX(altitude_max) AS altitude_max,
+       SUM(altitude_count)::bigint AS altitude_count,
+       SUM(course_avg * course_count) / NULLIF(SUM(course_count), 0) AS course_avg,
+       MIN(course_min) AS course_min,
+       MAX(course_max) AS course_max,
+       SUM(course_count)::bigint AS course_count,
+       SUM(latitude_avg * latitude_count) / NULLIF(SUM(latitude_count), 0) AS latitude_avg,
+       MIN(latitude_min) AS latitude_min,
+       MAX(latitude_max) AS latitude_max,
+       SUM(latitude_count)::bigint AS latitude_count,
+       SUM(longitude_avg * longitude_count) / NULLIF(SUM(longitude_count), 0) AS longitude_avg,
+       MIN(longitude_min) AS longitude_min,
+       MAX(longitude_max) AS longitude_max,
+       SUM(longitude_count)::bigint AS longitude_count,
+       SUM(articulation_angle_avg * articulation_angle_count) / NULLIF(SUM(articulation_angle_count), 0) AS articulation_angle_avg,
+       MIN(articulation_angle_min) AS articulation_angle_min,
+       MAX(articulation_angle_max) AS articulation_angle_max,
+       SUM(articulation_angle_count)::bigint AS articulation_angle_count,
+       SUM(steering_angle_avg * steering_angle_count) / NULLIF(SUM(steering_angle_count), 0) AS steering_angle_avg,
+       MIN(steering_angle_min) AS steering_angle_min,
+       MAX(steering_angle_max) AS steering_angle_max,
+       SUM(steering_angle_count)::bigint AS steering_angle_count,
+       SUM(wheel_speed_fl_avg * wheel_speed_fl_count) / NULLIF(SUM(wheel_speed_fl_count), 0) AS wheel_speed_fl_avg,
+       MIN(wheel_speed_fl_min) AS wheel_speed_fl_min,
+       MAX(wheel_speed_fl_max) AS wheel_speed_fl_max,
+       SUM(wheel_speed_fl_count)::bigint AS wheel_speed_fl_count,
+       SUM(wheel_speed_fr_avg * wheel_speed_fr_count) / NULLIF(SUM(wheel_speed_fr_count), 0) AS wheel_speed_fr_avg,
+       MIN(wheel_speed_fr_min) AS wheel_speed_fr_min,
+       MAX(wheel_speed_fr_max) AS wheel_speed_fr_max,
+       SUM(wheel_speed_fr_count)::bigint AS wheel_speed_fr_count,
+       SUM(wheel_speed_ml_avg * wheel_speed_ml_count) / NULLIF(SUM(wheel_speed_ml_count), 0) AS wheel_speed_ml_avg,
+       MIN(wheel_speed_ml_min) AS wheel_speed_ml_min,
+       MAX(wheel_speed_ml_max) AS wheel_speed_ml_max,
+       SUM(wheel_speed_ml_count)::bigint AS wheel_speed_ml_count,
+       SUM(wheel_speed_mr_avg * wheel_speed_mr_count) / NULLIF(SUM(wheel_speed_mr_count), 0) AS wheel_speed_mr_avg,
+       MIN(wheel_speed_mr_min) AS wheel_speed_mr_min,
+       MAX(wheel_speed_mr_max) AS wheel_speed_mr_max,
+       SUM(wheel_speed_mr_count)::bigint AS wheel_speed_mr_count,
+       SUM(wheel_speed_rl_avg * wheel_speed_rl_count) / NULLIF(SUM(wheel_speed_rl_count), 0) AS wheel_speed_rl_avg,
+       MIN(wheel_speed_rl_min) AS wheel_speed_rl_min,
+       MAX(wheel_speed_rl_max) AS wheel_speed_rl_max,
+       SUM(wheel_speed_rl_count)::bigint AS wheel_speed_rl_count,
+       SUM(wheel_speed_rr_avg * wheel_speed_rr_count) / NULLIF(SUM(wheel_speed_rr_count), 0) AS wheel_speed_rr_avg,
+       MIN(wheel_speed_rr_min) AS wheel_speed_rr_min,
+       MAX(wheel_speed_rr_max) AS wheel_speed_rr_max,
+       SUM(wheel_speed_rr_count)::bigint AS wheel_speed_rr_count,
+       SUM(door_open_avg * door_open_count) / NULLIF(SUM(door_open_count), 0) AS door_open_avg,
+       MIN(door_open_min) AS door_open_min,
+       MAX(door_open_max) AS door_open_max,
+       SUM(door_open_count)::bigint AS door_open_count,
+       SUM(grid_available_avg * grid_available_count) / NULLIF(SUM(grid_available_count), 0) AS grid_available_avg,
+       MIN(grid_available_min) AS grid_available_min,
+       MAX(grid_available_max) AS grid_available_max,
+       SUM(grid_available_count)::bigint AS grid_available_count,
+       SUM(halt_brake_avg * halt_brake_count) / NULLIF(SUM(halt_brake_count), 0) AS halt_brake_avg,
+       MIN(halt_brake_min) AS halt_brake_min,
+       MAX(halt_brake_max) AS halt_brake_max,
+       SUM(halt_brake_count)::bigint AS halt_brake_count,
+       SUM(park_brake_avg * park_brake_count) / NULLIF(SUM(park_brake_count), 0) AS park_brake_avg,
+       MIN(park_brake_min) AS park_brake_min,
+       MAX(park_brake_max) AS park_brake_max,
+       SUM(park_brake_count)::bigint AS park_brake_count
+FROM telemetry_1m
+GROUP BY time_bucket('1 hour', bucket), vehicle_id;
 
--- Power
-CREATE MATERIALIZED VIEW IF NOT EXISTS trend_power_1min
-WITH (timescaledb.continuous) AS
-SELECT time_bucket('1 minute', time_iso) AS bucket,
+CREATE MATERIALIZED VIEW IF NOT EXISTS telemetry_1d
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', bucket) AS bucket,
        vehicle_id,
-       AVG(electric_power_demand) AS avg_power
+       SUM(speed_avg * speed_count) / NULLIF(SUM(speed_count), 0) AS speed_avg,
+       MIN(speed_min) AS speed_min,
+       MAX(speed_max) AS speed_max,
+       SUM(speed_count)::bigint AS speed_count,
+       SUM(power_avg * power_count) / NULLIF(SUM(power_count), 0) AS power_avg,
+       MIN(power_min) AS power_min,
+       MAX(power_max) AS power_max,
+       SUM(power_count)::bigint AS power_count,
+       SUM(temp_avg * temp_count) / NULLIF(SUM(temp_count), 0) AS temp_avg,
+       MIN(temp_min) AS temp_min,
+       MAX(temp_max) AS temp_max,
+       SUM(temp_count)::bigint AS temp_count,
+       SUM(traction_force_avg * traction_force_count) / NULLIF(SUM(traction_force_count), 0) AS traction_force_avg,
+       MIN(traction_force_min) AS traction_force_min,
+       MAX(traction_force_max) AS traction_force_max,
+       SUM(traction_force_count)::bigint AS traction_force_count,
+       SUM(brake_pressure_avg * brake_pressure_count) / NULLIF(SUM(brake_pressure_count), 0) AS brake_pressure_avg,
+       MIN(brake_pressure_min) AS brake_pressure_min,
+       MAX(brake_pressure_max) AS brake_pressure_max,
+       SUM(brake_pressure_count)::bigint AS brake_pressure_count,
+       SUM(passengers_avg * passengers_count) / NULLIF(SUM(passengers_count), 0) AS passengers_avg,
+       MIN(passengers_min) AS passengers_min,
+       MAX(passengers_max) AS passengers_max,
+       SUM(passengers_count)::bigint AS passengers_count,
+       SUM(altitude_avg * altitude_count) / NULLIF(SUM(altitude_count), 0) AS altitude_avg,
+       MIN(altitude_min) AS altitude_min,
+       MAX(altitude_max) AS altitude_max,
+       SUM(altitude_count)::bigint AS altitude_count,
+       SUM(course_avg * course_count) / NULLIF(SUM(course_count), 0) AS course_avg,
+       MIN(course_min) AS course_min,
+       MAX(course_max) AS course_max,
+       SUM(course_count)::bigint AS course_count,
+       SUM(latitude_avg * latitude_count) / NULLIF(SUM(latitude_count), 0) AS latitude_avg,
+       MIN(latitude_min) AS latitude_min,
+       MAX(latitude_max) AS latitude_max,
+       SUM(latitude_count)::bigint AS latitude_count,
+       SUM(longitude_avg * longitude_count) / NULLIF(SUM(longitude_count), 0) AS longitude_avg,
+       MIN(longitude_min) AS longitude_min,
+       MAX(longitude_max) AS longitude_max,
+       SUM(longitude_count)::bigint AS longitude_count,
+       SUM(articulation_angle_avg * articulation_angle_count) / NULLIF(SUM(articulation_angle_count), 0) AS articulation_angle_avg,
+       MIN(articulation_angle_min) AS articulation_angle_min,
+       MAX(articulation_angle_max) AS articulation_angle_max,
+       SUM(articulation_angle_count)::bigint AS articulation_angle_count,
+       SUM(steering_angle_avg * steering_angle_count) / NULLIF(SUM(steering_angle_count), 0) AS steering_angle_avg,
+       MIN(steering_angle_min) AS steering_angle_min,
+       MAX(steering_angle_max) AS steering_angle_max,
+       SUM(steering_angle_count)::bigint AS steering_angle_count,
+       SUM(wheel_speed_fl_avg * wheel_speed_fl_count) / NULLIF(SUM(wheel_speed_fl_count), 0) AS wheel_speed_fl_avg,
+       MIN(wheel_speed_fl_min) AS wheel_speed_fl_min,
+       MAX(wheel_speed_fl_max) AS wheel_speed_fl_max,
+       SUM(wheel_speed_fl_count)::bigint AS wheel_speed_fl_count,
+       SUM(wheel_speed_fr_avg * wheel_speed_fr_count) / NULLIF(SUM(wheel_speed_fr_count), 0) AS wheel_speed_fr_avg,
+       MIN(wheel_speed_fr_min) AS wheel_speed_fr_min,
+       MAX(wheel_speed_fr_max) AS wheel_speed_fr_max,
+       SUM(wheel_speed_fr_count)::bigint AS wheel_speed_fr_count,
+       SUM(wheel_speed_ml_avg * wheel_speed_ml_count) / NULLIF(SUM(wheel_speed_ml_count), 0) AS wheel_speed_ml_avg,
+       MIN(wheel_speed_ml_min) AS wheel_speed_ml_min,
+       MAX(wheel_speed_ml_max) AS wheel_speed_ml_max,
+       SUM(wheel_speed_ml_count)::bigint AS wheel_speed_ml_count,
+       SUM(wheel_speed_mr_avg * wheel_speed_mr_count) / NULLIF(SUM(wheel_speed_mr_count), 0) AS wheel_speed_mr_avg,
+       MIN(wheel_speed_mr_min) AS wheel_speed_mr_min,
+       MAX(wheel_speed_mr_max) AS wheel_speed_mr_max,
+       SUM(wheel_speed_mr_count)::bigint AS wheel_speed_mr_count,
+       SUM(wheel_speed_rl_avg * wheel_speed_rl_count) / NULLIF(SUM(wheel_speed_rl_count), 0) AS wheel_speed_rl_avg,
+       MIN(wheel_speed_rl_min) AS wheel_speed_rl_min,
+       MAX(wheel_speed_rl_max) AS wheel_speed_rl_max,
+       SUM(wheel_speed_rl_count)::bigint AS wheel_speed_rl_count,
+       SUM(wheel_speed_rr_avg * wheel_speed_rr_count) / NULLIF(SUM(wheel_speed_rr_count), 0) AS wheel_speed_rr_avg,
+       MIN(wheel_speed_rr_min) AS wheel_speed_rr_min,
+       MAX(wheel_speed_rr_max) AS wheel_speed_rr_max,
+       SUM(wheel_speed_rr_count)::bigint AS wheel_speed_rr_count,
+       SUM(door_open_avg * door_open_count) / NULLIF(SUM(door_open_count), 0) AS door_open_avg,
+       MIN(door_open_min) AS door_open_min,
+       MAX(door_open_max) AS door_open_max,
+       SUM(door_open_count)::bigint AS door_open_count,
+       SUM(grid_available_avg * grid_available_count) / NULLIF(SUM(grid_available_count), 0) AS grid_available_avg,
+       MIN(grid_available_min) AS grid_available_min,
+       MAX(grid_available_max) AS grid_available_max,
+       SUM(grid_available_count)::bigint AS grid_available_count,
+       SUM(halt_brake_avg * halt_brake_count) / NULLIF(SUM(halt_brake_count), 0) AS halt_brake_avg,
+       MIN(halt_brake_min) AS halt_brake_min,
+       MAX(halt_brake_max) AS halt_brake_max,
+       SUM(halt_brake_count)::bigint AS halt_brake_count,
+       SUM(park_brake_avg * park_brake_count) / NULLIF(SUM(park_brake_count), 0) AS park_brake_avg,
+       MIN(park_brake_min) AS park_brake_min,
+       MAX(park_brake_max) AS park_brake_max,
+       SUM(park_brake_count)::bigint AS park_brake_count
+FROM telemetry_1h
+GROUP BY time_bucket('1 day', bucket), vehicle_id;
+
+-- Vehicle activity per day and route, backs GET /vehicles without scanning telemetry
+CREATE MATERIALIZED VIEW IF NOT EXISTS vehicle_activity_1day
+WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+SELECT time_bucket('1 day', time_iso) AS bucket,
+       vehicle_id,
+       itcs_bus_route AS route,
+       MIN(time_iso) AS first_sample,
+       MAX(time_iso) AS last_sample,
+       COUNT(*) AS row_count
 FROM telemetry
-GROUP BY bucket, vehicle_id;
+GROUP BY bucket, vehicle_id, route;
 
--- Add refresh policies (refresh every 5 minutes, look back 1 hour)
-SELECT add_continuous_aggregate_policy('trend_speed_1min',
+-- Add refresh policies, each tier after the one below it. if_not_exists keeps
+-- this file re-runnable on existing databases.
+SELECT add_continuous_aggregate_policy('telemetry_1m',
     start_offset => NULL,
     -- start_offset => INTERVAL '1 hour',
     end_offset   => INTERVAL '1 minute',
-    schedule_interval => INTERVAL '1 minute');
+    schedule_interval => INTERVAL '1 minute',
+    if_not_exists => TRUE);
 
-SELECT add_continuous_aggregate_policy('trend_temp_1min',
+SELECT add_continuous_aggregate_policy('telemetry_1h',
     start_offset => NULL,
-    -- start_offset => INTERVAL '1 hour',
-    end_offset   => INTERVAL '1 minute',
-    schedule_interval => INTERVAL '1 minute');
+    end_offset   => INTERVAL '1 hour',
+    schedule_interval => INTERVAL '5 minutes',
+    if_not_exists => TRUE);
 
-SELECT add_continuous_aggregate_policy('trend_power_1min',
+SELECT add_continuous_aggregate_policy('telemetry_1d',
+    start_offset => NULL,
+    end_offset   => INTERVAL '1 day',
+    schedule_interval => INTERVAL '1 hour',
+    if_not_exists => TRUE);
+
+SELECT add_continuous_aggregate_policy('vehicle_activity_1day',
     start_offset => NULL,
-    -- start_offset => INTERVAL '1 hour',
     end_offset   => INTERVAL '1 minute',
-    schedule_interval => INTERVAL '1 minute');
+    schedule_interval => INTERVAL '1 minute',
+    if_not_exists => TRUE);
 
 -- NOTIFY
 -- 1. Create a notification function
@@ -100,15 +424,9 @@ RETURNS TRIGGER AS $$
 BEGIN
   PERFORM pg_notify(
     'telemetry_channel',
-    json_build_object(
-      'vehicle_id', NEW.vehicle_id,
-      'time_iso',   NEW.time_iso,
-      'speed',      NEW.odometry_vehicle_speed,
-      'temp',       NEW.temperature_ambient,
-      'power',      NEW.electric_power_demand,
-      'traction',   NEW.traction_traction_force,
-      'brake',      NEW.traction_brake_pressure
-    )::text
+    -- The whole row keyed by column name, metrics are looked up through the
+    -- backend metric registry
+    to_jsonb(NEW)::text
   );
   RETURN NEW;
 END;
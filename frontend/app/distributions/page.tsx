@@ -5,11 +5,11 @@ import DistributionChart from "../../components/charts/DistributionChart";
 import FilterBarWithMetric, {
   Filters,
 } from "../../components/filters/FilterBarWithMetric";
-import { metricsConfig } from "@/config/metrics";
+import { defaultMetric } from "@/config/metrics";
 
 const initialFilters: Filters = {
   vehicle: "B183",
-  metric: metricsConfig[0].value,
+  metric: defaultMetric,
   from: "2019-06-24T03:16:00Z",
   to: "2019-06-24T03:20:00Z",
 };
@@ -2,6 +2,35 @@
 
 import { useState } from "react";
 
+type IngestJob = {
+  id: string;
+  state: "queued" | "running" | "succeeded" | "partial" | "failed";
+  rows_processed: number;
+  inserted: number;
+  error?: string;
+};
+
+const POLL_INTERVAL_MS = 1000;
+
+const pollJob = async (
+  jobId: string,
+  onProgress: (job: IngestJob) => void
+): Promise<IngestJob> => {
+  while (true) {
+    const res = await fetch(`http://localhost:8080/ingest-jobs/${jobId}`);
+    if (!res.ok) {
+      throw new Error(`Job status failed: ${res.statusText}`);
+    }
+
+    const job: IngestJob = await res.json();
+    if (job.state !== "queued" && job.state !== "running") {
+      return job;
+    }
+    onProgress(job);
+    await new Promise((resolve) => setTimeout(resolve, POLL_INTERVAL_MS));
+  }
+};
+
 export default function IngestionPage() {
   const [file, setFile] = useState<File | null>(null);
   const [uploading, setUploading] = useState(false);
@@ -17,7 +46,7 @@ export default function IngestionPage() {
 
   const handleUpload = async () => {
     if (!file) {
-      setMessage("Please select a CSV file first.");
+      setMessage("Please select a CSV file or archive first.");
       return;
     }
 
@@ -38,8 +67,23 @@ export default function IngestionPage() {
       }
 
       const json = await res.json();
-      setMessage(`Upload successful: ${json.inserted || "Unknown"} rows`);
       setIsSuccess(true);
+      setMessage("Upload queued...");
+
+      const job = await pollJob(json.job_id, (progress) => {
+        setMessage(
+          `Ingesting (${progress.state}): ${progress.rows_processed} rows processed`
+        );
+      });
+      if (job.state === "failed") {
+        throw new Error(`Ingest failed: ${job.error || "Unknown error"}`);
+      }
+
+      setMessage(
+        job.state === "partial"
+          ? `Upload partially successful: ${job.inserted} rows (${job.error})`
+          : `Upload successful: ${job.inserted} rows`
+      );
       setFile(null);
     } catch (err: any) {
       setMessage(`Error: ${err.message}`);
@@ -60,7 +104,7 @@ export default function IngestionPage() {
         <div className="bg-gray-800 p-6 rounded-xl shadow space-y-4">
           <input
             type="file"
-            accept=".csv"
+            accept=".csv,.gz,.zst,.zip,.parquet,.arrow,.arrows"
             onChange={handleFileChange}
             className="block w-full text-sm text-gray-300
                        file:mr-4 file:py-2 file:px-4
@@ -85,7 +129,7 @@ export default function IngestionPage() {
                 : "bg-blue-600 hover:bg-blue-700"
             }`}
           >
-            {uploading ? "Uploading..." : "Upload"}
+            {uploading ? "Processing..." : "Upload"}
           </button>
 
           {message && (
@@ -3,11 +3,13 @@
 import { useState } from "react";
 import { useLiveTrend } from "@/hooks/useLiveTrend";
 import TrendChart from "@/components/charts/TrendChart";
-import { metricsConfig } from "@/config/metrics";
+import { defaultMetric } from "@/config/metrics";
+import { useMetrics } from "@/hooks/useMetrics";
 
 export default function LiveTrendPage() {
   const [vehicle, setVehicle] = useState("B183");
-  const [metric, setMetric] = useState(metricsConfig[0].value);
+  const [metric, setMetric] = useState(defaultMetric);
+  const { metrics } = useMetrics({ live: true });
 
   const { points, error, isConnected } = useLiveTrend(vehicle, metric);
 
@@ -35,7 +37,8 @@ export default function LiveTrendPage() {
             onChange={(e) => setMetric(e.target.value)}
             className="border rounded p-2 bg-gray-700 text-white"
           >
-            {metricsConfig.map((m) => (
+            {metrics.length === 0 && <option value={metric}>{metric}</option>}
+            {metrics.map((m) => (
               <option key={m.value} value={m.value}>
                 {m.label}
               </option>
@@ -2,7 +2,7 @@
 
 import { useState } from "react";
 import FilterBarBase from "./FilterBarBase";
-import { metricsConfig } from "@/config/metrics"; // <-- import config
+import { useMetrics } from "@/hooks/useMetrics";
 
 export type Filters = {
   vehicle: string;
@@ -21,6 +21,7 @@ export default function FilterBarWithMetric({
   onApply,
 }: FilterBarWithMetricProps) {
   const [metric, setMetric] = useState(initialFilters.metric);
+  const { metrics, error } = useMetrics();
 
   return (
     <div className="space-y-4">
@@ -37,12 +38,15 @@ export default function FilterBarWithMetric({
           onChange={(e) => setMetric(e.target.value)}
           className="border rounded p-2 bg-gray-700 text-white"
         >
-          {metricsConfig.map((m) => (
+          {/* Keep the selected metric listed until the registry arrives */}
+          {metrics.length === 0 && <option value={metric}>{metric}</option>}
+          {metrics.map((m) => (
             <option key={m.value} value={m.value}>
               {m.label}
             </option>
           ))}
         </select>
+        {error && <p className="text-sm text-red-400 mt-1">{error}</p>}
       </div>
     </div>
   );
@@ -1,6 +1,3 @@
-export const metricsConfig = [
-  { value: "speed", label: "Speed" },
-  { value: "temp", label: "Temperature" },
-  { value: "power", label: "Power Demand" },
-  { value: "traction_force", label: "Traction Force" },
-];
+// Metrics are loaded from the backend registry (GET /metrics, see
+// hooks/useMetrics.ts); this is the one selected before they arrive.
+export const defaultMetric = "speed";
@@ -0,0 +1,45 @@
+"use client";
+import { Metric } from "@/types";
+import { useEffect, useState } from "react";
+
+export type MetricOption = {
+  value: string;
+  label: string;
+};
+
+// Label shown in metric selectors, with the unit when there is one.
+function metricLabel(m: Metric): string {
+  return m.unit ? `${m.name} (${m.unit})` : m.name;
+}
+
+// useMetrics loads the metric registry from the backend. With live set, only
+// metrics available on /live-trend are returned.
+export function useMetrics(
+  { live = false }: { live?: boolean } = {},
+  url = "http://localhost:8080/metrics"
+) {
+  const [metrics, setMetrics] = useState<MetricOption[]>([]);
+  const [error, setError] = useState<string | null>(null);
+
+  useEffect(() => {
+    const fetchMetrics = async () => {
+      try {
+        const res = await fetch(url);
+        if (!res.ok) throw new Error("Failed to fetch metrics");
+        const data: Metric[] = await res.json();
+        setMetrics(
+          data
+            .filter((m) => !live || m.live)
+            .map((m) => ({ value: m.id, label: metricLabel(m) }))
+        );
+      } catch (err) {
+        console.error("Failed to load metrics:", err);
+        setError("Could not load metrics");
+      }
+    };
+
+    fetchMetrics();
+  }, [live, url]);
+
+  return { metrics, error };
+}
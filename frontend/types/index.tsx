@@ -2,3 +2,14 @@ export type TrendPoint = {
   timestamp: string;
   value: number;
 };
+
+// Metric as listed by GET /metrics.
+export type Metric = {
+  id: string;
+  column: string;
+  name: string;
+  unit: string;
+  type: "float" | "integer" | "boolean";
+  aggregatable: boolean;
+  live: boolean;
+};